@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/disaster37/check_elasticsearch/v7/checkes"
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// runBatch run all checks declared on checks file with a bounded worker pool and print one result per check.
+// It exit with the worst status.
+func runBatch(c *cli.Context) error {
+
+	if c.Int("workers") <= 0 {
+		return errors.New("--workers parameter must be positive")
+	}
+	output := c.String("output")
+	switch output {
+	case "nagios", "json":
+	case "passive":
+		if c.String("host-name") == "" {
+			return errors.New("You must set --host-name parameter with passive output")
+		}
+	default:
+		return errors.Errorf("Output %s is not supported", output)
+	}
+
+	checks, err := loadCheckDefinitions(c.String("checks"))
+	if err != nil {
+		return err
+	}
+
+	if c.Duration("timeout") > 0 {
+		ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+		defer cancel()
+		c.Context = ctx
+	}
+	monitorES, err := checkes.NewMonitorESFromContext(c)
+	if err != nil {
+		return err
+	}
+	globalArgs := globalCheckArgs(c)
+
+	// Run checks
+	results := make([]*checkResult, len(checks))
+	jobs := make(chan int)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < c.Int("workers"); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range jobs {
+				results[idx] = runCheck(c.Context, monitorES, checks[idx], globalArgs)
+			}
+		}()
+	}
+	for idx := range checks {
+		jobs <- idx
+	}
+	close(jobs)
+	wg.Wait()
+
+	status := worstStatus(results)
+
+	switch output {
+	case "nagios":
+		fmt.Println(batchToNagios(checks, results).Output())
+	case "json":
+		documents := make([]*checkes.MonitoringResult, 0, len(results))
+		for idx, result := range results {
+			document := result.monitoringData.ToResult(checks[idx].Name)
+			document.Cluster = monitorES.ClusterName()
+			document.Duration = result.duration.Seconds()
+			documents = append(documents, document)
+		}
+		b, err := json.Marshal(documents)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+	case "passive":
+		for idx, result := range results {
+			fmt.Println(toPassiveResult(c.String("host-name"), checks[idx].Name, result))
+		}
+	}
+
+	os.Exit(status)
+	return nil
+}
+
+// worstStatus return the worst status of check results
+func worstStatus(results []*checkResult) int {
+	status := nagiosPlugin.STATUS_OK
+	for _, result := range results {
+		if result.monitoringData.Status() > status {
+			status = result.monitoringData.Status()
+		}
+	}
+
+	return status
+}
+
+// batchToNagios merge the results of checks on one Nagios multi-line result.
+// The perfdata labels are prefixed by the check name
+func batchToNagios(checks []checkDefinition, results []*checkResult) *checkes.Monitoring {
+
+	monitoringData := checkes.NewMonitoring()
+	nbChecksByStatus := make(map[int]int, 4)
+	for _, result := range results {
+		monitoringData.SetStatus(result.monitoringData.Status())
+		nbChecksByStatus[result.monitoringData.Status()]++
+	}
+	monitoringData.AddMessage("%d checks: %d OK, %d WARNING, %d CRITICAL, %d UNKNOWN", len(results), nbChecksByStatus[nagiosPlugin.STATUS_OK], nbChecksByStatus[nagiosPlugin.STATUS_WARNING], nbChecksByStatus[nagiosPlugin.STATUS_CRITICAL], nbChecksByStatus[nagiosPlugin.STATUS_UNKNOWN])
+
+	for idx, result := range results {
+		for i, message := range result.monitoringData.Messages() {
+			if i == 0 {
+				monitoringData.AddMessage("[%s] %s - %s", checks[idx].Name, result.monitoringData.StatusName(), message)
+			} else {
+				monitoringData.AddMessage("[%s] %s", checks[idx].Name, message)
+			}
+		}
+		for _, perfdata := range result.monitoringData.Perfdatas() {
+			monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_%s", checks[idx].Name, perfdata.Label()), perfdata.Value(), perfdata.Unit(), result.monitoringData.PerfdataThresholds(perfdata.Label()))
+		}
+	}
+
+	return monitoringData
+}
+
+// toPassiveResult return the check result as Nagios external command
+func toPassiveResult(hostName string, serviceName string, result *checkResult) string {
+	return fmt.Sprintf("[%d] PROCESS_SERVICE_CHECK_RESULT;%s;%s;%d;%s", result.lastRun.Unix(), hostName, serviceName, result.monitoringData.Status(), strings.ReplaceAll(result.monitoringData.ToString(), "\n", `\n`))
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWorstStatus(t *testing.T) {
+
+	testCases := []struct {
+		name     string
+		statuses []int
+		expected int
+	}{
+		{
+			name:     "no result",
+			expected: nagiosPlugin.STATUS_OK,
+		},
+		{
+			name:     "all ok",
+			statuses: []int{nagiosPlugin.STATUS_OK, nagiosPlugin.STATUS_OK},
+			expected: nagiosPlugin.STATUS_OK,
+		},
+		{
+			name:     "warning and critical",
+			statuses: []int{nagiosPlugin.STATUS_WARNING, nagiosPlugin.STATUS_CRITICAL, nagiosPlugin.STATUS_OK},
+			expected: nagiosPlugin.STATUS_CRITICAL,
+		},
+		{
+			name:     "unknown",
+			statuses: []int{nagiosPlugin.STATUS_CRITICAL, nagiosPlugin.STATUS_UNKNOWN},
+			expected: nagiosPlugin.STATUS_UNKNOWN,
+		},
+	}
+
+	for _, testCase := range testCases {
+		results := make([]*checkResult, 0, len(testCase.statuses))
+		for _, status := range testCase.statuses {
+			results = append(results, newTestCheckResult(status, "message", time.Now()))
+		}
+		assert.Equal(t, testCase.expected, worstStatus(results), testCase.name)
+	}
+}
+
+func TestBatchToNagios(t *testing.T) {
+
+	checks := []checkDefinition{{Name: "health"}, {Name: "locked"}}
+	locked := newTestCheckResult(nagiosPlugin.STATUS_CRITICAL, "Some indices are locked", time.Now())
+	locked.monitoringData.AddMessage("Indice foo: read_only_allow_delete")
+	results := []*checkResult{
+		newTestCheckResult(nagiosPlugin.STATUS_OK, "Cluster is green", time.Now()),
+		locked,
+	}
+
+	monitoringData := batchToNagios(checks, results)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+	assert.Equal(t, `CRITICAL - 2 checks: 1 OK, 0 WARNING, 1 CRITICAL, 0 UNKNOWN
+[health] OK - Cluster is green
+[locked] CRITICAL - Some indices are locked
+[locked] Indice foo: read_only_allow_delete|health_nbIndicesLocked=0;;0;; locked_nbIndicesLocked=2;;0;; `, monitoringData.Output())
+}
+
+func TestToPassiveResult(t *testing.T) {
+
+	result := newTestCheckResult(nagiosPlugin.STATUS_WARNING, "Some indices are locked", time.Unix(1600000000, 0))
+	result.monitoringData.AddMessage("Indice foo: write")
+
+	assert.Equal(t, `[1600000000] PROCESS_SERVICE_CHECK_RESULT;es-prod;locked;1;Some indices are locked\nIndice foo: write|nbIndicesLocked=1;;0;; `, toPassiveResult("es-prod", "locked", result))
+}
@@ -2,9 +2,14 @@ package checkes
 
 import (
 	"context"
-	"crypto/tls"
-	"net/http"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	elastic "github.com/elastic/go-elasticsearch/v7"
 	"github.com/pkg/errors"
@@ -14,67 +19,335 @@ import (
 
 // CheckES is implementation of MonitorES
 type CheckES struct {
-	client *elastic.Client
+	client      *elastic.Client
+	clusterName string
+}
+
+// InfoResponse is the API response
+type InfoResponse struct {
+	ClusterName string `json:"cluster_name"`
 }
 
 // MonitorES is interface of elasticsearch monitoring
 type MonitorES interface {
-	CheckILMError(indiceName string, excludeIndices []string) (*nagiosPlugin.Monitoring, error)
-	CheckILMStatus() (*nagiosPlugin.Monitoring, error)
-	CheckSLMError(snapshotRepositoryName string) (*nagiosPlugin.Monitoring, error)
-	CheckSLMStatus() (*nagiosPlugin.Monitoring, error)
-	CheckSLMPolicy(policyName string) (*nagiosPlugin.Monitoring, error)
-	CheckIndiceLocked(indiceName string) (*nagiosPlugin.Monitoring, error)
-	CheckTransformError(transformName string, excludeTransforms []string) (*nagiosPlugin.Monitoring, error)
+	ClusterName() string
+	CheckILMError(ctx context.Context, indiceName string, indiceFilter *filter.Filter, thresholds *Thresholds, retryOptions *ILMRetryOptions) (*Monitoring, error)
+	CheckILMStatus(ctx context.Context) (*Monitoring, error)
+	CheckILMStuck(ctx context.Context, indiceName string, indiceFilter *filter.Filter, maxStepAge time.Duration, thresholds *Thresholds) (*Monitoring, error)
+	CheckSLMError(ctx context.Context, snapshotRepositoryName string, options *SnapshotCheckOptions, thresholds *Thresholds, ageThresholds *Thresholds) (*Monitoring, error)
+	CheckSLMStatus(ctx context.Context) (*Monitoring, error)
+	CheckSLMRetention(ctx context.Context, retentionThresholds *Thresholds, deletionThresholds *Thresholds, stateFile string) (*Monitoring, error)
+	CheckRepositoryVerify(ctx context.Context, repositoryName string, thresholds *Thresholds) (*Monitoring, error)
+	CheckSLMPolicy(ctx context.Context, policyName string, options *SLMPolicyCheckOptions, thresholds *Thresholds) (*Monitoring, error)
+	CheckIndiceLocked(ctx context.Context, indiceName string, indiceFilter *filter.Filter, blocks []IndiceBlock, thresholds *Thresholds, unlockOptions *IndiceUnlockOptions) (*Monitoring, error)
+	CheckTransformError(ctx context.Context, transformName string, transformFilter *filter.Filter, options *TransformCheckOptions, thresholds *Thresholds) (*Monitoring, error)
+	CheckClusterHealth(ctx context.Context, minNodes int, minDataNodes int, thresholds *Thresholds) (*Monitoring, error)
+	CheckUnassignedShards(ctx context.Context, maxExplain int, thresholds *Thresholds) (*Monitoring, error)
+	CheckNodeDisk(ctx context.Context, thresholds *Thresholds) (*Monitoring, error)
+	CheckNodeJVM(ctx context.Context, heapThresholds *Thresholds, gcRateThresholds *Thresholds, breakerThresholds *Thresholds, stateFile string) (*Monitoring, error)
+	CheckThreadPool(ctx context.Context, pools []string, queueThresholds *Thresholds, rejectedThresholds *Thresholds, stateFile string) (*Monitoring, error)
+}
+
+const (
+	// metadataMonitorES is the app metadata key of the Elasticsearch client shared between checks
+	metadataMonitorES = "monitorES"
+	// metadataResultHandler is the app metadata key of the handler that receive the check result
+	metadataResultHandler = "resultHandler"
+	// metadataStartTime is the app metadata key of the time when check start
+	metadataStartTime = "startTime"
+)
+
+// ResultHandler permit to receive the check result instead to print it on stdout
+type ResultHandler func(monitoringData *Monitoring)
+
+// SetSharedMonitorES permit to use the same Elasticsearch client for all checks run by the app
+func SetSharedMonitorES(app *cli.App, monitorES MonitorES) {
+	if app.Metadata == nil {
+		app.Metadata = make(map[string]interface{})
+	}
+	app.Metadata[metadataMonitorES] = monitorES
+}
+
+// SetResultHandler permit to receive the check result run by the app instead to print it on stdout
+func SetResultHandler(app *cli.App, handler ResultHandler) {
+	if app.Metadata == nil {
+		app.Metadata = make(map[string]interface{})
+	}
+	app.Metadata[metadataResultHandler] = handler
+}
+
+// Config is the settings to connect on Elasticsearch cluster
+type Config struct {
+	URLs                   []string
+	Sniff                  bool
+	CloudID                string
+	Username               string
+	Password               string
+	APIKey                 string
+	ServiceToken           string
+	DisableTLSVerification bool
+	CACert                 string
+	ClientCert             string
+	ClientKey              string
+	TLSServerName          string
+	CAFingerprint          string
+	Retries                int
+}
+
+// NewMonitorESFromContext permit to initialize connexion on Elasticsearch cluster from the global parameters
+func NewMonitorESFromContext(c *cli.Context) (MonitorES, error) {
+
+	urls := manageURLParameter(c)
+	if len(urls) == 0 && c.String("cloud-id") == "" {
+		return nil, errors.New("You must set --url or --cloud-id parameter")
+	}
+	if c.Int("retries") < 0 {
+		return nil, errors.New("--retries parameter can't be negative")
+	}
+
+	return NewCheckESFromConfig(c.Context, &Config{
+		URLs:                   urls,
+		Sniff:                  c.Bool("sniff"),
+		CloudID:                c.String("cloud-id"),
+		Username:               c.String("user"),
+		Password:               c.String("password"),
+		APIKey:                 c.String("api-key"),
+		ServiceToken:           c.String("service-token"),
+		DisableTLSVerification: c.Bool("self-signed-certificate"),
+		CACert:                 c.String("ca-cert"),
+		ClientCert:             c.String("client-cert"),
+		ClientKey:              c.String("client-key"),
+		TLSServerName:          c.String("tls-server-name"),
+		CAFingerprint:          c.String("ca-fingerprint"),
+		Retries:                c.Int("retries"),
+	})
+}
+
+// manageURLParameter return the Elasticsearch URLs set by repeated --url or by comma separated list
+func manageURLParameter(c *cli.Context) []string {
+	urls := make([]string, 0, len(c.StringSlice("url")))
+	for _, rawURL := range c.StringSlice("url") {
+		for _, url := range strings.Split(rawURL, ",") {
+			if url = strings.TrimSpace(url); url != "" {
+				urls = append(urls, url)
+			}
+		}
+	}
+
+	return urls
 }
 
 func manageElasticsearchGlobalParameters(c *cli.Context) (MonitorES, error) {
 
-	if c.String("url") == "" {
-		return nil, errors.New("You must set --url parameter")
+	if monitorES, ok := c.App.Metadata[metadataMonitorES].(MonitorES); ok {
+		return monitorES, nil
+	}
+
+	monitorES, err := NewMonitorESFromContext(c)
+	if err != nil {
+		return nil, err
+	}
+	SetSharedMonitorES(c.App, monitorES)
+
+	return monitorES, nil
+
+}
+
+// WrapCheckAction measure the duration of check, stop it after --timeout and output the error as unknown check result.
+// When the check timeout, the status is set by --timeout-status
+func WrapCheckAction(action cli.ActionFunc) cli.ActionFunc {
+	return func(c *cli.Context) error {
+		if c.App.Metadata == nil {
+			c.App.Metadata = make(map[string]interface{})
+		}
+		c.App.Metadata[metadataStartTime] = time.Now()
+
+		timeoutStatus, err := ParseStatus(c.String("timeout-status"))
+		if err != nil {
+			return errors.Wrap(err, "Error when parse --timeout-status parameter")
+		}
+		if c.Context == nil {
+			c.Context = context.Background()
+		}
+		if c.Duration("timeout") > 0 {
+			ctx, cancel := context.WithTimeout(c.Context, c.Duration("timeout"))
+			defer cancel()
+			c.Context = ctx
+		}
+
+		if err := action(c); err != nil {
+			monitoringData := NewMonitoring()
+			if errors.Is(err, context.DeadlineExceeded) || errors.Is(c.Context.Err(), context.DeadlineExceeded) {
+				monitoringData.SetStatus(timeoutStatus)
+				monitoringData.AddMessage("Timeout after %s: %s", c.Duration("timeout"), err)
+			} else {
+				monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+				monitoringData.AddMessage("Error appear during check: %s", err)
+			}
+			return outputMonitoring(c, monitoringData)
+		}
+		return nil
+	}
+}
+
+// outputMonitoring give the check result to the result handler if any, else it print it on stdout with the format set by --output and exit
+func outputMonitoring(c *cli.Context, monitoringData *Monitoring) error {
+
+	if handler, ok := c.App.Metadata[metadataResultHandler].(ResultHandler); ok {
+		handler(monitoringData)
+		return nil
+	}
+
+	switch c.String("output") {
+	case "", "nagios":
+		monitoringData.ToSdtOut()
+	case "json":
+		result := monitoringData.ToResult(c.Command.Name)
+		if monitorES, ok := c.App.Metadata[metadataMonitorES].(MonitorES); ok {
+			result.Cluster = monitorES.ClusterName()
+		}
+		if startTime, ok := c.App.Metadata[metadataStartTime].(time.Time); ok {
+			result.Duration = time.Since(startTime).Seconds()
+		}
+		b, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(b))
+		os.Exit(monitoringData.Status())
+	default:
+		return errors.Errorf("Output %s is not supported", c.String("output"))
 	}
 
-	return NewCheckES(c.String("url"), c.String("user"), c.String("password"), c.Bool("self-signed-certificate"))
+	return nil
+}
+
+// manageThresholdParameters read the --warning and --critical Nagios ranges
+func manageThresholdParameters(c *cli.Context) (*Thresholds, error) {
+	return NewThresholds(c.String("warning"), c.String("critical"))
+}
+
+// manageFilterParameters read the --include and --exclude patterns
+func manageFilterParameters(c *cli.Context) (*filter.Filter, error) {
+	return filter.New(patternsParameter(c, "include"), patternsParameter(c, "exclude"))
+}
 
+// patternsParameter return the patterns of flag, not split on comma
+func patternsParameter(c *cli.Context, name string) []string {
+	patterns, ok := c.Generic(name).(*filter.Patterns)
+	if !ok || patterns == nil {
+		return nil
+	}
+	return *patterns
 }
 
-//NewCheckES permit to initialize connexion on Elasticsearch cluster
+// NewCheckES permit to initialize connexion on Elasticsearch cluster
 func NewCheckES(URL string, username string, password string, disableTLSVerification bool) (MonitorES, error) {
 
 	if URL == "" {
 		return nil, errors.New("URL can't be empty")
 	}
-	log.Debugf("URL: %s", URL)
-	log.Debugf("User: %s", username)
+
+	return NewCheckESFromConfig(context.Background(), &Config{
+		URLs:                   []string{URL},
+		Username:               username,
+		Password:               password,
+		DisableTLSVerification: disableTLSVerification,
+	})
+}
+
+// NewCheckESFromConfig permit to initialize connexion on Elasticsearch cluster with basic auth, API key or service token.
+// The context is only used to connect on cluster
+func NewCheckESFromConfig(ctx context.Context, config *Config) (MonitorES, error) {
+
+	if config == nil {
+		return nil, errors.New("Config can't be nil")
+	}
+	if len(config.URLs) == 0 && config.CloudID == "" {
+		return nil, errors.New("URLs or CloudID can't be empty")
+	}
+	if len(config.URLs) > 0 && config.CloudID != "" {
+		return nil, errors.New("You can't set URLs and CloudID at the same time")
+	}
+	if config.Sniff && config.CloudID != "" {
+		return nil, errors.New("You can't sniff nodes with CloudID")
+	}
+	log.Debugf("URLs: %s", strings.Join(config.URLs, ", "))
+	log.Debugf("Sniff: %t", config.Sniff)
+	log.Debugf("CloudID: %s", config.CloudID)
+	log.Debugf("User: %s", config.Username)
 	log.Debugf("Password: xxx")
+	log.Debugf("APIKey: xxx")
+	log.Debugf("ServiceToken: xxx")
 	checkES := &CheckES{}
 
+	// The retries are managed by retryTransport
 	cfg := elastic.Config{
-		Addresses: []string{URL},
+		Addresses:    config.URLs,
+		CloudID:      config.CloudID,
+		DisableRetry: true,
 	}
-	if username != "" && password != "" {
-		cfg.Username = username
-		cfg.Password = password
+	switch {
+	case config.APIKey != "":
+		cfg.APIKey = encodeAPIKey(config.APIKey)
+	case config.ServiceToken != "":
+		cfg.ServiceToken = config.ServiceToken
+	case config.Username != "" && config.Password != "":
+		cfg.Username = config.Username
+		cfg.Password = config.Password
 	}
-	if disableTLSVerification {
-		cfg.Transport = &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}
+	if config.needTLSConfig() {
+		transport, err := newTransport(config)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Transport = transport
 	}
 	client, err := elastic.NewClient(cfg)
 	if err != nil {
 		return nil, err
 	}
+	client.Transport = newRetryTransport(client.Transport, len(config.URLs), config.Retries)
+
+	// Discover nodes before the first request, the failure is not blocking because the URLs are still used
+	if config.Sniff {
+		if err = client.DiscoverNodes(); err != nil {
+			log.Warnf("Error when discover nodes: %s", err.Error())
+		}
+	}
 
 	res, err := client.API.Info(
-		client.API.Info.WithContext(context.Background()),
+		client.API.Info.WithContext(ctx),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error when connecting on Elasticsearch")
 	}
 
+	defer res.Body.Close()
 	if res.IsError() {
 		return nil, errors.Errorf("Error when connecting on Elasticsearch: %s", res.String())
 	}
+	infoResponse := &InfoResponse{}
+	if err = json.NewDecoder(res.Body).Decode(infoResponse); err != nil {
+		return nil, err
+	}
+	log.Debugf("Connected on cluster %s", infoResponse.ClusterName)
 
 	checkES.client = client
+	checkES.clusterName = infoResponse.ClusterName
 	return checkES, nil
 }
+
+// ClusterName return the name of the Elasticsearch cluster
+func (h *CheckES) ClusterName() string {
+	return h.clusterName
+}
+
+// encodeAPIKey return the API key encoded in base64 as expected by Elasticsearch.
+// It accept the API key as `id:key` or already encoded
+func encodeAPIKey(apiKey string) string {
+	if strings.Contains(apiKey, ":") {
+		return base64.StdEncoding.EncodeToString([]byte(apiKey))
+	}
+	return apiKey
+}
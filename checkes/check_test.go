@@ -1,11 +1,15 @@
 package checkes
 
 import (
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
 	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
+	"github.com/urfave/cli/v2"
 	prefixed "github.com/x-cray/logrus-prefixed-formatter"
 )
 
@@ -40,6 +44,42 @@ func (s *CheckESTestSuite) SetupTest() {
 
 }
 
+// newTestESServer return a fake Elasticsearch server that answer to the product check and call handler for the other requests
+func newTestESServer(handler http.HandlerFunc) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/" {
+			w.Write([]byte(`{"cluster_name":"test","version":{"number":"7.17.0"}}`))
+			return
+		}
+		handler(w, r)
+	}))
+}
+
 func TestCheckESTestSuite(t *testing.T) {
 	suite.Run(t, new(CheckESTestSuite))
 }
+
+func TestEncodeAPIKey(t *testing.T) {
+	assert.Equal(t, "aWQ6a2V5", encodeAPIKey("id:key"))
+	assert.Equal(t, "aWQ6a2V5", encodeAPIKey("aWQ6a2V5"))
+}
+
+func TestManageURLParameter(t *testing.T) {
+	var urls []string
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name: "url",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		urls = manageURLParameter(c)
+		return nil
+	}
+
+	err := app.Run([]string{"test", "--url", "http://node1:9200,http://node2:9200", "--url", "http://node3:9200"})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"http://node1:9200", "http://node2:9200", "http://node3:9200"}, urls)
+}
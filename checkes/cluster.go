@@ -0,0 +1,135 @@
+package checkes
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// ClusterHealthResponse is the API response
+type ClusterHealthResponse struct {
+	ClusterName                 string `json:"cluster_name"`
+	Status                      string `json:"status"`
+	TimedOut                    bool   `json:"timed_out"`
+	NumberOfNodes               int    `json:"number_of_nodes"`
+	NumberOfDataNodes           int    `json:"number_of_data_nodes"`
+	ActivePrimaryShards         int    `json:"active_primary_shards"`
+	ActiveShards                int    `json:"active_shards"`
+	RelocatingShards            int    `json:"relocating_shards"`
+	InitializingShards          int    `json:"initializing_shards"`
+	UnassignedShards            int    `json:"unassigned_shards"`
+	DelayedUnassignedShards     int    `json:"delayed_unassigned_shards"`
+	NumberOfPendingTasks        int    `json:"number_of_pending_tasks"`
+	NumberOfInFlightFetch       int    `json:"number_of_in_flight_fetch"`
+	TaskMaxWaitingInQueueMillis int    `json:"task_max_waiting_in_queue_millis"`
+}
+
+// CheckClusterHealth wrap command line to check
+func CheckClusterHealth(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Int("min-nodes") < 0 {
+		return errors.New("--min-nodes parameter can't be negative")
+	}
+	if c.Int("min-data-nodes") < 0 {
+		return errors.New("--min-data-nodes parameter can't be negative")
+	}
+
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckClusterHealth(c.Context, c.Int("min-nodes"), c.Int("min-data-nodes"), thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckClusterHealth check the cluster health status and the number of nodes.
+// Set minNodes or minDataNodes to 0 to disable the nodes check.
+// The thresholds are applied on the number of unassigned shards
+func (h *CheckES) CheckClusterHealth(ctx context.Context, minNodes int, minDataNodes int, thresholds *Thresholds) (*Monitoring, error) {
+
+	log.Debugf("MinNodes: %d", minNodes)
+	log.Debugf("MinDataNodes: %d", minDataNodes)
+	monitoringData := NewMonitoring()
+
+	// Query the cluster health
+	res, err := h.client.API.Cluster.Health(
+		h.client.API.Cluster.Health.WithContext(ctx),
+		h.client.API.Cluster.Health.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when get cluster health")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get cluster health: %s", res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get cluster health successfully:\n%s", string(b))
+	clusterHealthResponse := &ClusterHealthResponse{}
+	err = json.Unmarshal(b, clusterHealthResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Compute the status from the cluster health
+	switch clusterHealthResponse.Status {
+	case "green":
+		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
+	case "yellow":
+		monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+	case "red":
+		monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+	default:
+		monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+	}
+	monitoringData.AddMessage("Cluster %s is %s", clusterHealthResponse.ClusterName, clusterHealthResponse.Status)
+
+	// Check the number of nodes
+	if minNodes > 0 && clusterHealthResponse.NumberOfNodes < minNodes {
+		monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+		monitoringData.AddMessage("There are only %d nodes (expected at least %d)", clusterHealthResponse.NumberOfNodes, minNodes)
+	}
+	if minDataNodes > 0 && clusterHealthResponse.NumberOfDataNodes < minDataNodes {
+		monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+		monitoringData.AddMessage("There are only %d data nodes (expected at least %d)", clusterHealthResponse.NumberOfDataNodes, minDataNodes)
+	}
+
+	// Check the number of unassigned shards
+	monitoringData.SetStatus(thresholds.Status(float64(clusterHealthResponse.UnassignedShards)))
+	if clusterHealthResponse.UnassignedShards > 0 {
+		monitoringData.AddMessage("There are %d unassigned shards (%d delayed)", clusterHealthResponse.UnassignedShards, clusterHealthResponse.DelayedUnassignedShards)
+	}
+	if clusterHealthResponse.RelocatingShards > 0 || clusterHealthResponse.InitializingShards > 0 {
+		monitoringData.AddMessage("There are %d relocating shards and %d initializing shards", clusterHealthResponse.RelocatingShards, clusterHealthResponse.InitializingShards)
+	}
+
+	monitoringData.AddPerfdata("activeShards", clusterHealthResponse.ActiveShards, "")
+	monitoringData.AddPerfdata("activePrimaryShards", clusterHealthResponse.ActivePrimaryShards, "")
+	monitoringData.AddPerfdata("relocatingShards", clusterHealthResponse.RelocatingShards, "")
+	monitoringData.AddPerfdata("initializingShards", clusterHealthResponse.InitializingShards, "")
+	monitoringData.AddPerfdataWithThresholds("unassignedShards", clusterHealthResponse.UnassignedShards, "", thresholds)
+	monitoringData.AddPerfdata("delayedUnassignedShards", clusterHealthResponse.DelayedUnassignedShards, "")
+	monitoringData.AddPerfdata("nbNodes", clusterHealthResponse.NumberOfNodes, "")
+	monitoringData.AddPerfdata("nbDataNodes", clusterHealthResponse.NumberOfDataNodes, "")
+	monitoringData.AddPerfdata("nbPendingTasks", clusterHealthResponse.NumberOfPendingTasks, "")
+
+	return monitoringData, nil
+}
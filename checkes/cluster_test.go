@@ -0,0 +1,34 @@
+package checkes
+
+import (
+	"context"
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *CheckESTestSuite) TestCheckClusterHealth() {
+
+	// When check cluster health without nodes check
+	monitoringData, err := s.monitorES.CheckClusterHealth(context.Background(), 0, 0, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.NotEqual(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+
+	// When there are enought nodes
+	monitoringData, err = s.monitorES.CheckClusterHealth(context.Background(), 1, 1, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.NotEqual(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+
+	// When some nodes are missing
+	monitoringData, err = s.monitorES.CheckClusterHealth(context.Background(), 10, 0, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+
+	// When some data nodes are missing
+	monitoringData, err = s.monitorES.CheckClusterHealth(context.Background(), 0, 10, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+}
@@ -4,23 +4,111 @@ import (
 	"context"
 	"encoding/json"
 	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 )
 
-//ILMExplainResponse is the API response
+// ILMExplainResponse is the API response
 type ILMExplainResponse struct {
 	Indices map[string]ILMExplain `json:"indices,omitempty"`
 }
 
 // ILMExplain is the API response
 type ILMExplain struct {
-	Index    string    `json:"index,omitempty"`
-	Policy   string    `json:"policy,omitempty"`
-	StepInfo *StepInfo `json:"step_info,omitempty"`
+	Index           string             `json:"index,omitempty"`
+	Managed         bool               `json:"managed,omitempty"`
+	Policy          string             `json:"policy,omitempty"`
+	Phase           string             `json:"phase,omitempty"`
+	Action          string             `json:"action,omitempty"`
+	Step            string             `json:"step,omitempty"`
+	PhaseTimeMillis int64              `json:"phase_time_millis,omitempty"`
+	StepTimeMillis  int64              `json:"step_time_millis,omitempty"`
+	StepInfo        *StepInfo          `json:"step_info,omitempty"`
+	PhaseExecution  *ILMPhaseExecution `json:"phase_execution,omitempty"`
+}
+
+// ILMPhaseExecution is the API response
+type ILMPhaseExecution struct {
+	Policy          string             `json:"policy,omitempty"`
+	PhaseDefinition ILMPhaseDefinition `json:"phase_definition,omitempty"`
+}
+
+// ILMPhaseDefinition is the API response
+type ILMPhaseDefinition struct {
+	MinAge  string     `json:"min_age,omitempty"`
+	Actions ILMActions `json:"actions,omitempty"`
+}
+
+// ILMActions is the API response
+type ILMActions struct {
+	Rollover *ILMRolloverAction `json:"rollover,omitempty"`
+}
+
+// ILMRolloverAction is the API response
+type ILMRolloverAction struct {
+	MaxAge string `json:"max_age,omitempty"`
+}
+
+// StepAge return the time spent on the current step
+func (e ILMExplain) StepAge(now time.Time) time.Duration {
+	if e.StepTimeMillis == 0 {
+		return 0
+	}
+	return now.Sub(time.Unix(0, e.StepTimeMillis*int64(time.Millisecond)))
+}
+
+// MaxStepAge return the maximum time the indice can stay on the current step.
+// On step check-rollover-ready, the indice wait the rollover conditions, so the rollover max_age is added to maxStepAge.
+// It return false when the step can't be stuck, like rollover without max_age that wait the size or the number of documents
+func (e ILMExplain) MaxStepAge(maxStepAge time.Duration) (time.Duration, bool, error) {
+	if e.Step != "check-rollover-ready" {
+		return maxStepAge, true, nil
+	}
+	if e.PhaseExecution == nil || e.PhaseExecution.PhaseDefinition.Actions.Rollover == nil || e.PhaseExecution.PhaseDefinition.Actions.Rollover.MaxAge == "" {
+		return 0, false, nil
+	}
+	rolloverMaxAge, err := parseTimeValue(e.PhaseExecution.PhaseDefinition.Actions.Rollover.MaxAge)
+	if err != nil {
+		return 0, false, errors.Wrapf(err, "Error when read rollover max_age of policy %s", e.Policy)
+	}
+
+	return maxStepAge + rolloverMaxAge, true, nil
+}
+
+// parseTimeValue convert Elasticsearch time unit like 30d or 12h as duration
+func parseTimeValue(rawTime string) (time.Duration, error) {
+	units := []struct {
+		suffix string
+		unit   time.Duration
+	}{
+		{"nanos", time.Nanosecond},
+		{"micros", time.Microsecond},
+		{"ms", time.Millisecond},
+		{"s", time.Second},
+		{"m", time.Minute},
+		{"h", time.Hour},
+		{"d", 24 * time.Hour},
+	}
+	rawTime = strings.ToLower(strings.TrimSpace(rawTime))
+	for _, unit := range units {
+		if strings.HasSuffix(rawTime, unit.suffix) {
+			value, err := strconv.ParseFloat(strings.TrimSuffix(rawTime, unit.suffix), 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "Error when parse time %s", rawTime)
+			}
+			return time.Duration(value * float64(unit.unit)), nil
+		}
+	}
+
+	return 0, errors.Errorf("Time %s has no unit", rawTime)
 }
 
 // StepInfo is the API response
@@ -30,6 +118,26 @@ type StepInfo struct {
 	StackTrace string `json:"stack_trace,omitempty"`
 }
 
+// ILMRetryOptions is the settings to retry ILM on failed indices
+type ILMRetryOptions struct {
+	MaxRetriesPerRun    int
+	MaxRetriesPerIndice int
+	DryRun              bool
+	AttemptsExpiration  time.Duration
+	StateFile           string
+}
+
+// ILMRetryState is the ILM retry attempts stored between two runs
+type ILMRetryState struct {
+	Indices map[string]ILMRetryAttempt `json:"indices"`
+}
+
+// ILMRetryAttempt is the ILM retry attempts on indice
+type ILMRetryAttempt struct {
+	Attempts  int       `json:"attempts"`
+	LastRetry time.Time `json:"last_retry"`
+}
+
 // ILMStatusResponse is the API response
 type ILMStatusResponse struct {
 	OperationMode string `json:"operation_mode,omitempty"`
@@ -47,13 +155,74 @@ func CheckILMError(c *cli.Context) error {
 		return errors.New("You must set --indice parameter")
 	}
 
-	monitoringData, err := monitorES.CheckILMError(c.String("indice"), c.StringSlice("exclude"))
+	thresholds, err := manageThresholdParameters(c)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
 
-	return nil
+	indiceFilter, err := manageFilterParameters(c)
+	if err != nil {
+		return err
+	}
+
+	var retryOptions *ILMRetryOptions
+	if c.Bool("auto-retry") {
+		if c.Int("max-retries-per-run") <= 0 {
+			return errors.New("--max-retries-per-run parameter must be positive")
+		}
+		if c.Int("max-retries-per-indice") <= 0 {
+			return errors.New("--max-retries-per-indice parameter must be positive")
+		}
+		if c.Duration("retries-expiration") <= 0 {
+			return errors.New("--retries-expiration parameter must be positive")
+		}
+		retryOptions = &ILMRetryOptions{
+			MaxRetriesPerRun:    c.Int("max-retries-per-run"),
+			MaxRetriesPerIndice: c.Int("max-retries-per-indice"),
+			DryRun:              c.Bool("dry-run"),
+			AttemptsExpiration:  c.Duration("retries-expiration"),
+			StateFile:           manageStateFileParameter(c, "ilm-retry", c.String("indice"), indiceFilter.String()),
+		}
+	}
+
+	monitoringData, err := monitorES.CheckILMError(c.Context, c.String("indice"), indiceFilter, thresholds, retryOptions)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckILMStuck wrap command line to check
+func CheckILMStuck(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	if c.String("indice") == "" {
+		return errors.New("You must set --indice parameter")
+	}
+	if c.Duration("max-step-age") <= 0 {
+		return errors.New("--max-step-age parameter must be positive")
+	}
+
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	indiceFilter, err := manageFilterParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckILMStuck(c.Context, c.String("indice"), indiceFilter, c.Duration("max-step-age"), thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
 
 }
 
@@ -65,36 +234,36 @@ func CheckILMStatus(c *cli.Context) error {
 		return err
 	}
 
-	monitoringData, err := monitorES.CheckILMStatus()
+	monitoringData, err := monitorES.CheckILMStatus(c.Context)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
-
-	return nil
+	return outputMonitoring(c, monitoringData)
 
 }
 
 // CheckILMError check that there are no ILM policy failed on indice name
-func (h *CheckES) CheckILMError(indiceName string, excludeIndices []string) (*nagiosPlugin.Monitoring, error) {
+// The thresholds are applied on the number of failed indices.
+// When retryOptions is set, it retry ILM on failed indices. The status is not changed by the retries
+func (h *CheckES) CheckILMError(ctx context.Context, indiceName string, indiceFilter *filter.Filter, thresholds *Thresholds, retryOptions *ILMRetryOptions) (*Monitoring, error) {
 
 	if indiceName == "" {
 		return nil, errors.New("IndiceName can't be empty")
 	}
 	log.Debugf("IndiceName: %s", indiceName)
-	log.Debugf("ExcludeIndices: %+v", excludeIndices)
-	monitoringData := nagiosPlugin.NewMonitoring()
+	log.Debugf("IndiceFilter: %s", indiceFilter)
+	monitoringData := NewMonitoring()
 
 	// Query if there are ILM error
 	res, err := h.client.API.ILM.ExplainLifecycle(
 		indiceName,
-		h.client.API.ILM.ExplainLifecycle.WithContext(context.Background()),
+		h.client.API.ILM.ExplainLifecycle.WithContext(ctx),
 		h.client.API.ILM.ExplainLifecycle.WithOnlyErrors(true),
 		h.client.API.ILM.ExplainLifecycle.WithOnlyManaged(true),
 		h.client.API.ILM.ExplainLifecycle.WithPretty(),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "Error when get ILM explain of indice %s", indiceName)
 	}
 	defer res.Body.Close()
 	if res.IsError() {
@@ -116,51 +285,235 @@ func (h *CheckES) CheckILMError(indiceName string, excludeIndices []string) (*na
 		return nil, err
 	}
 
-	// Check if there are some ILM polices that failed
-	if ilmExplainResponse.Indices == nil || len(ilmExplainResponse.Indices) == 0 {
-		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
-		monitoringData.AddMessage("No error found on indice %s", indiceName)
-		monitoringData.AddPerfdata("NbIndiceFailed", 0, "")
-		return monitoringData, nil
-	}
-
 	// Remove exclude indices
-	for _, indiceExcludeName := range excludeIndices {
-		if _, ok := ilmExplainResponse.Indices[indiceExcludeName]; ok {
-			log.Debugf("Indice %s is exclude", indiceExcludeName)
-			delete(ilmExplainResponse.Indices, indiceExcludeName)
+	for indice := range ilmExplainResponse.Indices {
+		if !indiceFilter.Match(indice) {
+			log.Debugf("Indice %s is exclude", indice)
+			delete(ilmExplainResponse.Indices, indice)
 		}
 	}
 
 	// Compute error
+	monitoringData.SetStatus(thresholds.Status(float64(len(ilmExplainResponse.Indices))))
+	monitoringData.AddPerfdataWithThresholds("NbIndiceFailed", len(ilmExplainResponse.Indices), "", thresholds)
 	if len(ilmExplainResponse.Indices) == 0 {
-		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
 		monitoringData.AddMessage("No error found on indice %s", indiceName)
-		monitoringData.AddPerfdata("NbIndiceFailed", 0, "")
 		return monitoringData, nil
 	}
-	monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
-	monitoringData.AddPerfdata("NbIndiceFailed", len(ilmExplainResponse.Indices), "")
 	monitoringData.AddMessage("There are %d indices failed", len(ilmExplainResponse.Indices))
-	for _, ilmExplain := range ilmExplainResponse.Indices {
-		monitoringData.AddMessage("Indice %s (%s): %s", ilmExplain.Index, ilmExplain.Policy, ilmExplain.StepInfo.Reason)
+	indices := make([]string, 0, len(ilmExplainResponse.Indices))
+	for indice := range ilmExplainResponse.Indices {
+		indices = append(indices, indice)
+	}
+	sort.Strings(indices)
+	for _, indice := range indices {
+		ilmExplain := ilmExplainResponse.Indices[indice]
+		reason := ""
+		if ilmExplain.StepInfo != nil {
+			reason = ilmExplain.StepInfo.Reason
+		}
+		monitoringData.AddMessage("Indice %s (%s): %s", ilmExplain.Index, ilmExplain.Policy, reason)
+	}
+
+	if retryOptions != nil {
+		if err = h.retryILM(ctx, indices, retryOptions, monitoringData); err != nil {
+			return nil, err
+		}
+	}
+
+	return monitoringData, nil
+}
+
+// retryILM retry ILM on failed indices, within the limits of retries per run and per indice.
+// The retry attempts are stored on state file, and forgotten when the last retry is older than the attempts expiration,
+// so an indice that fail again just after a retry keep its attempts
+func (h *CheckES) retryILM(ctx context.Context, failedIndices []string, retryOptions *ILMRetryOptions, monitoringData *Monitoring) error {
+
+	log.Debugf("MaxRetriesPerRun: %d", retryOptions.MaxRetriesPerRun)
+	log.Debugf("MaxRetriesPerIndice: %d", retryOptions.MaxRetriesPerIndice)
+	log.Debugf("DryRun: %t", retryOptions.DryRun)
+	log.Debugf("AttemptsExpiration: %s", retryOptions.AttemptsExpiration)
+	log.Debugf("StateFile: %s", retryOptions.StateFile)
+
+	// The state file is locked until the new state is saved, so concurrent checks not lose samples
+	unlock := lockStateFile(retryOptions.StateFile)
+	defer unlock()
+	previousState := &ILMRetryState{}
+	if err := loadState(retryOptions.StateFile, previousState); err != nil {
+		return err
+	}
+
+	// Keep the attempts of all indices until they expire
+	now := time.Now()
+	currentState := &ILMRetryState{
+		Indices: make(map[string]ILMRetryAttempt, len(previousState.Indices)),
+	}
+	for indice, attempt := range previousState.Indices {
+		if now.Sub(attempt.LastRetry) > retryOptions.AttemptsExpiration {
+			log.Debugf("ILM retry attempts on indice %s expired", indice)
+			continue
+		}
+		currentState.Indices[indice] = attempt
+	}
+
+	nbRetries := 0
+	for _, indice := range failedIndices {
+		attempt := currentState.Indices[indice]
+
+		if attempt.Attempts >= retryOptions.MaxRetriesPerIndice {
+			monitoringData.AddMessage("Skip ILM retry on indice %s: already retried %d times", indice, attempt.Attempts)
+			continue
+		}
+		if nbRetries >= retryOptions.MaxRetriesPerRun {
+			monitoringData.AddMessage("Skip ILM retry on indice %s: max retries per run reached", indice)
+			continue
+		}
+		nbRetries++
+
+		if retryOptions.DryRun {
+			monitoringData.AddMessage("Dry run: ILM retry on indice %s not issued", indice)
+			continue
+		}
+
+		attempt.Attempts++
+		attempt.LastRetry = now
+		currentState.Indices[indice] = attempt
+
+		res, err := h.client.API.ILM.Retry(
+			indice,
+			h.client.API.ILM.Retry.WithContext(ctx),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Error when retry ILM on indice %s", indice)
+		}
+		res.Body.Close()
+		if res.IsError() {
+			log.Debugf("Retry ILM on indice %s failed: %s", indice, res.String())
+			monitoringData.AddMessage("ILM retry on indice %s rejected: %s", indice, res.Status())
+			continue
+		}
+		log.Debugf("Retry ILM on indice %s successfully", indice)
+		monitoringData.AddMessage("ILM retry on indice %s accepted (attempt %d/%d)", indice, attempt.Attempts, retryOptions.MaxRetriesPerIndice)
+	}
+	monitoringData.AddPerfdata("nbILMRetries", nbRetries, "")
+
+	if retryOptions.DryRun {
+		return nil
+	}
+	return saveState(retryOptions.StateFile, currentState)
+}
+
+// CheckILMStuck check that there are no indice stay on the same ILM step longer than maxStepAge.
+// The indices with completed policy are ignored. The indices waiting rollover can stay the rollover max_age more.
+// The thresholds are applied on the number of stuck indices
+func (h *CheckES) CheckILMStuck(ctx context.Context, indiceName string, indiceFilter *filter.Filter, maxStepAge time.Duration, thresholds *Thresholds) (*Monitoring, error) {
+
+	if indiceName == "" {
+		return nil, errors.New("IndiceName can't be empty")
+	}
+	log.Debugf("IndiceName: %s", indiceName)
+	log.Debugf("IndiceFilter: %s", indiceFilter)
+	log.Debugf("MaxStepAge: %s", maxStepAge)
+	monitoringData := NewMonitoring()
+
+	// Query the ILM state of all managed indices
+	res, err := h.client.API.ILM.ExplainLifecycle(
+		indiceName,
+		h.client.API.ILM.ExplainLifecycle.WithContext(ctx),
+		h.client.API.ILM.ExplainLifecycle.WithOnlyManaged(true),
+		h.client.API.ILM.ExplainLifecycle.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get ILM explain of indice %s", indiceName)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+			monitoringData.AddMessage("Indice %s not found", indiceName)
+			return monitoringData, nil
+		}
+		return nil, errors.Errorf("Error when get ILM explain on indice %s: %s", indiceName, res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get lifecycle explain on index %s successfully:\n%s", indiceName, string(b))
+	ilmExplainResponse := &ILMExplainResponse{}
+	err = json.Unmarshal(b, ilmExplainResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remove exclude indices
+	for indice := range ilmExplainResponse.Indices {
+		if !indiceFilter.Match(indice) {
+			log.Debugf("Indice %s is exclude", indice)
+			delete(ilmExplainResponse.Indices, indice)
+		}
+	}
+
+	// Search indices stuck on the same step
+	now := time.Now()
+	indices := make([]string, 0, len(ilmExplainResponse.Indices))
+	for indice := range ilmExplainResponse.Indices {
+		indices = append(indices, indice)
+	}
+	sort.Strings(indices)
+	stuckIndices := make([]ILMExplain, 0)
+	var oldestStepAge time.Duration
+	for _, indice := range indices {
+		ilmExplain := ilmExplainResponse.Indices[indice]
+		if ilmExplain.Step == "complete" {
+			continue
+		}
+		indiceMaxStepAge, canBeStuck, err := ilmExplain.MaxStepAge(maxStepAge)
+		if err != nil {
+			return nil, err
+		}
+		if !canBeStuck {
+			log.Debugf("Indice %s wait rollover without max_age, it can't be stuck", indice)
+			continue
+		}
+		stepAge := ilmExplain.StepAge(now)
+		if stepAge > oldestStepAge {
+			oldestStepAge = stepAge
+		}
+		if stepAge > indiceMaxStepAge {
+			log.Debugf("Indice %s is stuck on step %s since %s", indice, ilmExplain.Step, stepAge)
+			stuckIndices = append(stuckIndices, ilmExplain)
+		}
+	}
+
+	// Compute status
+	monitoringData.SetStatus(thresholds.Status(float64(len(stuckIndices))))
+	monitoringData.AddPerfdataWithThresholds("nbIndicesStuck", len(stuckIndices), "", thresholds)
+	monitoringData.AddPerfdata("oldestStepAge", int(oldestStepAge.Seconds()), "s")
+	if len(stuckIndices) == 0 {
+		monitoringData.AddMessage("No indice stuck more than %s on ILM step on indice %s", maxStepAge, indiceName)
+		return monitoringData, nil
+	}
+	monitoringData.AddMessage("There are %d indices stuck more than %s on ILM step", len(stuckIndices), maxStepAge)
+	for _, ilmExplain := range stuckIndices {
+		monitoringData.AddMessage("Indice %s (%s): %s/%s/%s since %s", ilmExplain.Index, ilmExplain.Policy, ilmExplain.Phase, ilmExplain.Action, ilmExplain.Step, ilmExplain.StepAge(now).Round(time.Second))
 	}
 
 	return monitoringData, nil
 }
 
 // CheckILMStatus check the status of ILM is running
-func (h *CheckES) CheckILMStatus() (*nagiosPlugin.Monitoring, error) {
+func (h *CheckES) CheckILMStatus(ctx context.Context) (*Monitoring, error) {
 
-	monitoringData := nagiosPlugin.NewMonitoring()
+	monitoringData := NewMonitoring()
 
 	// Check the ILM status
 	res, err := h.client.API.ILM.GetStatus(
-		h.client.API.ILM.GetStatus.WithContext(context.Background()),
+		h.client.API.ILM.GetStatus.WithContext(ctx),
 		h.client.API.ILM.GetStatus.WithPretty(),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error when get ILM status")
 	}
 	defer res.Body.Close()
 	if res.IsError() {
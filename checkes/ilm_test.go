@@ -2,23 +2,33 @@ package checkes
 
 import (
 	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	nagiosPlugin "github.com/disaster37/go-nagios"
 	"github.com/stretchr/testify/assert"
 )
 
 func (s *CheckESTestSuite) TestCheckILMError() {
 
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
 	checkES := s.monitorES.(*CheckES)
 
 	// When check all indices
-	monitoringData, err := s.monitorES.CheckILMError("_all", []string{})
+	monitoringData, err := s.monitorES.CheckILMError(context.Background(), "_all", nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When check all indices with exclude
-	monitoringData, err = s.monitorES.CheckILMError("_all", []string{"foo"})
+	indiceFilter, err := filter.New([]string{"logs-*", "re:^bar"}, []string{"foo", "logs-debug-*"})
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckILMError(context.Background(), "_all", indiceFilter, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
@@ -28,17 +38,164 @@ func (s *CheckESTestSuite) TestCheckILMError() {
 		"bar",
 		checkES.client.API.Indices.Create.WithContext(context.Background()),
 	)
-	monitoringData, err = s.monitorES.CheckILMError("bar", []string{})
+	monitoringData, err = s.monitorES.CheckILMError(context.Background(), "bar", nil, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When check indice that not exist
+	monitoringData, err = s.monitorES.CheckILMError(context.Background(), "foo", nil, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+
+	// When auto retry failed indices
+	retryOptions := &ILMRetryOptions{
+		MaxRetriesPerRun:    10,
+		MaxRetriesPerIndice: 3,
+		AttemptsExpiration:  24 * time.Hour,
+		StateFile:           filepath.Join(s.T().TempDir(), "ilm-retry.json"),
+	}
+	monitoringData, err = s.monitorES.CheckILMError(context.Background(), "_all", nil, thresholds, retryOptions)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+}
+
+func (s *CheckESTestSuite) TestCheckILMStuck() {
+
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
+	// When check all indices
+	monitoringData, err := s.monitorES.CheckILMStuck(context.Background(), "_all", nil, 24*time.Hour, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When check indice that not exist
-	monitoringData, err = s.monitorES.CheckILMError("foo", []string{})
+	monitoringData, err = s.monitorES.CheckILMStuck(context.Background(), "foo", nil, 24*time.Hour, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+}
+
+func TestRetryILM(t *testing.T) {
+
+	nbRetries := 0
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		nbRetries++
+		w.Write([]byte(`{"acknowledged":true}`))
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkES := monitorES.(*CheckES)
+	retryOptions := &ILMRetryOptions{
+		MaxRetriesPerRun:    10,
+		MaxRetriesPerIndice: 2,
+		AttemptsExpiration:  24 * time.Hour,
+		StateFile:           filepath.Join(t.TempDir(), "ilm-retry.json"),
+	}
+
+	// When indice is failed, it is retried
+	err = checkES.retryILM(context.Background(), []string{"logs-1"}, retryOptions, NewMonitoring())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, nbRetries)
+
+	// When indice is not failed anymore, its attempts are kept
+	err = checkES.retryILM(context.Background(), []string{}, retryOptions, NewMonitoring())
+	assert.NoError(t, err)
+
+	// When indice fail again, it is retried until max retries per indice
+	err = checkES.retryILM(context.Background(), []string{"logs-1"}, retryOptions, NewMonitoring())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, nbRetries)
+	err = checkES.retryILM(context.Background(), []string{"logs-1"}, retryOptions, NewMonitoring())
+	assert.NoError(t, err)
+	assert.Equal(t, 2, nbRetries)
+
+	// When attempts expire
+	state := &ILMRetryState{}
+	assert.NoError(t, loadState(retryOptions.StateFile, state))
+	attempt := state.Indices["logs-1"]
+	assert.Equal(t, 2, attempt.Attempts)
+	attempt.LastRetry = time.Now().Add(-25 * time.Hour)
+	state.Indices["logs-1"] = attempt
+	assert.NoError(t, saveState(retryOptions.StateFile, state))
+	err = checkES.retryILM(context.Background(), []string{}, retryOptions, NewMonitoring())
+	assert.NoError(t, err)
+	state = &ILMRetryState{}
+	assert.NoError(t, loadState(retryOptions.StateFile, state))
+	assert.Empty(t, state.Indices)
+}
+
+func TestILMExplainStepAge(t *testing.T) {
+	now := time.Now()
+
+	ilmExplain := ILMExplain{StepTimeMillis: now.Add(-2*time.Hour).UnixNano() / int64(time.Millisecond)}
+	assert.InDelta(t, float64(2*time.Hour), float64(ilmExplain.StepAge(now)), float64(time.Millisecond))
+
+	// When step time is unknown
+	ilmExplain = ILMExplain{}
+	assert.Equal(t, time.Duration(0), ilmExplain.StepAge(now))
+}
+
+func TestILMExplainMaxStepAge(t *testing.T) {
+
+	// When step is not rollover
+	ilmExplain := ILMExplain{Step: "wait-for-shard-history-leases"}
+	maxStepAge, canBeStuck, err := ilmExplain.MaxStepAge(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, canBeStuck)
+	assert.Equal(t, 24*time.Hour, maxStepAge)
+
+	// When rollover has max_age
+	ilmExplain = ILMExplain{
+		Step: "check-rollover-ready",
+		PhaseExecution: &ILMPhaseExecution{
+			PhaseDefinition: ILMPhaseDefinition{
+				Actions: ILMActions{
+					Rollover: &ILMRolloverAction{MaxAge: "30d"},
+				},
+			},
+		},
+	}
+	maxStepAge, canBeStuck, err = ilmExplain.MaxStepAge(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, canBeStuck)
+	assert.Equal(t, 31*24*time.Hour, maxStepAge)
+
+	// When rollover has no max_age
+	ilmExplain.PhaseExecution.PhaseDefinition.Actions.Rollover.MaxAge = ""
+	_, canBeStuck, err = ilmExplain.MaxStepAge(24 * time.Hour)
+	assert.NoError(t, err)
+	assert.False(t, canBeStuck)
+
+	// When max_age is wrong
+	ilmExplain.PhaseExecution.PhaseDefinition.Actions.Rollover.MaxAge = "foo"
+	_, _, err = ilmExplain.MaxStepAge(24 * time.Hour)
+	assert.Error(t, err)
+}
+
+func TestParseTimeValue(t *testing.T) {
+	duration, err := parseTimeValue("7d")
+	assert.NoError(t, err)
+	assert.Equal(t, 7*24*time.Hour, duration)
+
+	duration, err = parseTimeValue("1.5h")
+	assert.NoError(t, err)
+	assert.Equal(t, 90*time.Minute, duration)
+
+	duration, err = parseTimeValue("500ms")
+	assert.NoError(t, err)
+	assert.Equal(t, 500*time.Millisecond, duration)
 
+	_, err = parseTimeValue("10")
+	assert.Error(t, err)
 }
 
 func (s *CheckESTestSuite) TestCheckILMStatus() {
@@ -49,7 +206,7 @@ func (s *CheckESTestSuite) TestCheckILMStatus() {
 	checkES.client.API.ILM.Stop(
 		checkES.client.API.ILM.Stop.WithContext(context.Background()),
 	)
-	monitoringData, err := s.monitorES.CheckILMStatus()
+	monitoringData, err := s.monitorES.CheckILMStatus(context.Background())
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
@@ -58,7 +215,7 @@ func (s *CheckESTestSuite) TestCheckILMStatus() {
 	checkES.client.API.ILM.Start(
 		checkES.client.API.ILM.Start.WithContext(context.Background()),
 	)
-	monitoringData, err = s.monitorES.CheckILMStatus()
+	monitoringData, err = s.monitorES.CheckILMStatus(context.Background())
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
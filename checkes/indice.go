@@ -3,8 +3,12 @@ package checkes
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"sort"
+	"strings"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
@@ -32,6 +36,103 @@ type IndiceSettingBlock struct {
 	ReadOnlyAllowDelete string `json:"read_only_allow_delete,omitempty"`
 	ReadOnly            string `json:"read_only,omitempty"`
 	Write               string `json:"write,omitempty"`
+	Metadata            string `json:"metadata,omitempty"`
+}
+
+// IsSet return true if the block is set
+func (b *IndiceSettingBlock) IsSet(name string) bool {
+	if b == nil {
+		return false
+	}
+
+	switch name {
+	case "read":
+		return b.Read == "true"
+	case "read_only":
+		return b.ReadOnly == "true"
+	case "read_only_allow_delete":
+		return b.ReadOnlyAllowDelete == "true"
+	case "write":
+		return b.Write == "true"
+	case "metadata":
+		return b.Metadata == "true"
+	default:
+		return false
+	}
+}
+
+// isSupportedIndiceBlock return true if the block type exist
+func isSupportedIndiceBlock(name string) bool {
+	switch name {
+	case "read", "read_only", "read_only_allow_delete", "write", "metadata":
+		return true
+	default:
+		return false
+	}
+}
+
+// IndiceBlock is the block type to check and the maximum status it can raise.
+// The block is counted but raise no alert when severity is OK
+type IndiceBlock struct {
+	Name     string
+	Severity int
+}
+
+// defaultIndiceBlocks is the blocks checked when none are selected
+var defaultIndiceBlocks = []IndiceBlock{
+	{
+		Name:     "read_only_allow_delete",
+		Severity: nagiosPlugin.STATUS_CRITICAL,
+	},
+}
+
+// ParseIndiceBlocks parse the blocks like `read_only_allow_delete=critical,write=warning,read_only=ignore`.
+// The severity is critical when it not set
+func ParseIndiceBlocks(rawBlocks []string) ([]IndiceBlock, error) {
+
+	blocks := make([]IndiceBlock, 0, len(rawBlocks))
+	for _, rawBlock := range rawBlocks {
+		for _, item := range strings.Split(rawBlock, ",") {
+			item = strings.TrimSpace(item)
+			if item == "" {
+				continue
+			}
+			block := IndiceBlock{
+				Name:     item,
+				Severity: nagiosPlugin.STATUS_CRITICAL,
+			}
+			if idx := strings.Index(item, "="); idx >= 0 {
+				block.Name = item[:idx]
+				severity := item[idx+1:]
+				status, err := ParseSeverity(severity)
+				if err != nil {
+					return nil, errors.Wrapf(err, "Error when parse severity of block %s", block.Name)
+				}
+				block.Severity = status
+			}
+			if !isSupportedIndiceBlock(block.Name) {
+				return nil, errors.Errorf("Block %s is not supported", block.Name)
+			}
+			blocks = append(blocks, block)
+		}
+	}
+
+	return blocks, nil
+}
+
+// hasIndiceBlock return true if the block is in blocks
+func hasIndiceBlock(blocks []IndiceBlock, name string) bool {
+	for _, block := range blocks {
+		if block.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// IndiceUnlockOptions is the settings to unlock indices once the disk usage recover
+type IndiceUnlockOptions struct {
+	DryRun bool
 }
 
 // CheckIndiceLocked wrap command line to check
@@ -46,33 +147,63 @@ func CheckIndiceLocked(c *cli.Context) error {
 		return errors.New("You must set --indice parameter")
 	}
 
-	monitoringData, err := monitorES.CheckIndiceLocked(c.String("indice"))
+	thresholds, err := manageThresholdParameters(c)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
 
-	return nil
+	blocks, err := ParseIndiceBlocks(c.StringSlice("blocks"))
+	if err != nil {
+		return err
+	}
+
+	var unlockOptions *IndiceUnlockOptions
+	if c.Bool("auto-unlock") {
+		unlockOptions = &IndiceUnlockOptions{
+			DryRun: c.Bool("dry-run"),
+		}
+	}
+
+	indiceFilter, err := manageFilterParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckIndiceLocked(c.Context, c.String("indice"), indiceFilter, blocks, thresholds, unlockOptions)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
 
 }
 
-// CheckIndiceLocked check that there are indice locked by security (read_only_allow_delete)
-func (h *CheckES) CheckIndiceLocked(indiceName string) (*nagiosPlugin.Monitoring, error) {
+// CheckIndiceLocked check that there are indice locked by blocks, by default read_only_allow_delete set by security
+// The thresholds are applied on the number of indices locked by each block, and the status is capped by the block severity.
+// When unlockOptions is set, the read_only_allow_delete block must be checked and it remove this block of indices when all nodes that hold their shards are below the high watermark. The status is not changed by the unlocks
+func (h *CheckES) CheckIndiceLocked(ctx context.Context, indiceName string, indiceFilter *filter.Filter, blocks []IndiceBlock, thresholds *Thresholds, unlockOptions *IndiceUnlockOptions) (*Monitoring, error) {
 
 	if indiceName == "" {
 		return nil, errors.New("IndiceName can't be empty")
 	}
+	if len(blocks) == 0 {
+		blocks = defaultIndiceBlocks
+	}
+	if unlockOptions != nil && !hasIndiceBlock(blocks, "read_only_allow_delete") {
+		return nil, errors.New("Auto unlock need the read_only_allow_delete block to be checked")
+	}
 	log.Debugf("IndiceName: %s", indiceName)
-	monitoringData := nagiosPlugin.NewMonitoring()
+	log.Debugf("IndiceFilter: %s", indiceFilter)
+	log.Debugf("Blocks: %+v", blocks)
+	monitoringData := NewMonitoring()
 
 	// Query the indice settings
 	res, err := h.client.API.Indices.GetSettings(
-		h.client.API.Indices.GetSettings.WithContext(context.Background()),
+		h.client.API.Indices.GetSettings.WithContext(ctx),
 		h.client.API.Indices.GetSettings.WithPretty(),
 		h.client.API.Indices.GetSettings.WithIndex(indiceName),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "Error when get settings of indice %s", indiceName)
 	}
 	defer res.Body.Close()
 	if res.IsError() {
@@ -95,31 +226,160 @@ func (h *CheckES) CheckIndiceLocked(indiceName string) (*nagiosPlugin.Monitoring
 	}
 	log.Debugf("Index settings: %+v", indicesSettingResponse)
 
-	// Check if there are index that are read only by security
+	// Check if there are index that are locked by blocks
+	indices := make([]string, 0, len(indicesSettingResponse))
+	for indiceName := range indicesSettingResponse {
+		if !indiceFilter.Match(indiceName) {
+			log.Debugf("Indice %s is exclude", indiceName)
+			continue
+		}
+		indices = append(indices, indiceName)
+	}
+	sort.Strings(indices)
 	brokenIndices := make([]string, 0)
-	nbIndice := 0
-	for indiceName, indiceSetting := range indicesSettingResponse {
+	unlockableIndices := make([]string, 0)
+	brokenIndicesBlocks := make(map[string][]string)
+	nbIndicesByBlock := make(map[string]int, len(blocks))
+	for _, indiceName := range indices {
+		indiceSetting := indicesSettingResponse[indiceName]
 		log.Debugf("%s: %+v", indiceName, indiceSetting)
-		if indiceSetting.Settings.Indice.Blocks != nil && indiceSetting.Settings.Indice.Blocks.ReadOnlyAllowDelete == "true" {
+		if indiceSetting.Settings == nil || indiceSetting.Settings.Indice == nil {
+			continue
+		}
+		isBroken := false
+		for _, block := range blocks {
+			if !indiceSetting.Settings.Indice.Blocks.IsSet(block.Name) {
+				continue
+			}
+			nbIndicesByBlock[block.Name]++
+			brokenIndicesBlocks[indiceName] = append(brokenIndicesBlocks[indiceName], block.Name)
+			if block.Severity != nagiosPlugin.STATUS_OK {
+				isBroken = true
+			}
+			if block.Name == "read_only_allow_delete" {
+				unlockableIndices = append(unlockableIndices, indiceName)
+			}
+		}
+		if isBroken {
 			brokenIndices = append(brokenIndices, indiceName)
 		}
-		nbIndice++
 	}
 
+	for _, block := range blocks {
+		status := thresholds.Status(float64(nbIndicesByBlock[block.Name]))
+		if status > block.Severity {
+			status = block.Severity
+		}
+		monitoringData.SetStatus(status)
+	}
+	nbIndice := len(indices)
 	if len(brokenIndices) > 0 {
-		monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
 		monitoringData.AddMessage("There are some indice locked (%d/%d)", nbIndice-len(brokenIndices), nbIndice)
-		for _, indiceName := range brokenIndices {
-			monitoringData.AddMessage("\tIndice %s", indiceName)
-		}
-
 	} else {
-		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
 		monitoringData.AddMessage("No indice locked (%d/%d)", nbIndice, nbIndice)
 	}
+	for _, block := range blocks {
+		if nbIndicesByBlock[block.Name] > 0 {
+			monitoringData.AddMessage("Block %s: %d indices", block.Name, nbIndicesByBlock[block.Name])
+		}
+	}
+	for _, indiceName := range indices {
+		if blockNames, ok := brokenIndicesBlocks[indiceName]; ok {
+			monitoringData.AddMessage("\tIndice %s: %s", indiceName, strings.Join(blockNames, ", "))
+		}
+	}
 
 	monitoringData.AddPerfdata("nbIndices", nbIndice, "")
-	monitoringData.AddPerfdata("nbIndicesLocked", len(brokenIndices), "")
+	monitoringData.AddPerfdataWithThresholds("nbIndicesLocked", len(brokenIndices), "", thresholds)
+	for _, block := range blocks {
+		monitoringData.AddPerfdata(fmt.Sprintf("nbIndicesBlocked_%s", block.Name), nbIndicesByBlock[block.Name], "")
+	}
+
+	if unlockOptions != nil && len(unlockableIndices) > 0 {
+		if err = h.unlockIndices(ctx, unlockableIndices, unlockOptions, monitoringData); err != nil {
+			return nil, err
+		}
+	}
 
 	return monitoringData, nil
 }
+
+// unlockIndices remove the read_only_allow_delete block of indices when all nodes that hold their shards are below the high watermark.
+// The indices without assigned shards are kept locked because their disk can't be checked
+func (h *CheckES) unlockIndices(ctx context.Context, lockedIndices []string, unlockOptions *IndiceUnlockOptions, monitoringData *Monitoring) error {
+
+	log.Debugf("DryRun: %t", unlockOptions.DryRun)
+
+	diskWatermarks, err := h.getDiskWatermarks(ctx)
+	if err != nil {
+		return err
+	}
+	nodesStats, err := h.getNodesStats(ctx, "fs")
+	if err != nil {
+		return err
+	}
+	fsStatsByNode := make(map[string]NodeFSTotalStats, len(nodesStats.Nodes))
+	for _, nodeStats := range nodesStats.Nodes {
+		if nodeStats.FS != nil {
+			fsStatsByNode[nodeStats.Name] = nodeStats.FS.Total
+		}
+	}
+
+	// Search the nodes that hold the shards of locked indices
+	shards, err := h.getShards(ctx)
+	if err != nil {
+		return err
+	}
+	nodesByIndice := make(map[string][]string, len(lockedIndices))
+	for _, shard := range shards {
+		if shard.Node != "" {
+			nodesByIndice[shard.Index] = append(nodesByIndice[shard.Index], shard.Node)
+		}
+	}
+
+	nbIndicesUnlocked := 0
+	for _, indice := range lockedIndices {
+		if len(nodesByIndice[indice]) == 0 {
+			monitoringData.AddMessage("Keep indice %s locked: no assigned shards, not unlocked", indice)
+			continue
+		}
+		blockingNode := ""
+		for _, node := range nodesByIndice[indice] {
+			fsStats, ok := fsStatsByNode[node]
+			if !ok || diskWatermarks.High.IsExceeded(fsStats) {
+				blockingNode = node
+				break
+			}
+		}
+		if blockingNode != "" {
+			monitoringData.AddMessage("Keep indice %s locked: node %s is not below high watermark %s", indice, blockingNode, diskWatermarks.High)
+			continue
+		}
+
+		if unlockOptions.DryRun {
+			monitoringData.AddMessage("Dry run: indice %s not unlocked", indice)
+			continue
+		}
+
+		res, err := h.client.API.Indices.PutSettings(
+			strings.NewReader(`{"index.blocks.read_only_allow_delete": null}`),
+			h.client.API.Indices.PutSettings.WithContext(ctx),
+			h.client.API.Indices.PutSettings.WithIndex(indice),
+		)
+		if err != nil {
+			return errors.Wrapf(err, "Error when unlock indice %s", indice)
+		}
+		res.Body.Close()
+		if res.IsError() {
+			log.Debugf("Unlock indice %s failed: %s", indice, res.String())
+			monitoringData.AddMessage("Unlock indice %s rejected: %s", indice, res.Status())
+			continue
+		}
+		log.Debugf("Unlock indice %s successfully", indice)
+		nbIndicesUnlocked++
+		monitoringData.AddMessage("Indice %s unlocked", indice)
+	}
+	monitoringData.AddPerfdata("nbIndicesUnlocked", nbIndicesUnlocked, "")
+
+	return nil
+}
@@ -2,14 +2,20 @@ package checkes
 
 import (
 	"context"
+	"net/http"
 	"strings"
+	"testing"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	nagiosPlugin "github.com/disaster37/go-nagios"
 	"github.com/stretchr/testify/assert"
 )
 
 func (s *CheckESTestSuite) TestCheckIndiceLocked() {
 
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
 	checkES := s.monitorES.(*CheckES)
 
 	// Create index with lock settings
@@ -30,7 +36,7 @@ func (s *CheckESTestSuite) TestCheckIndiceLocked() {
 	)
 
 	// When check all indices
-	monitoringData, err := s.monitorES.CheckIndiceLocked("_all")
+	monitoringData, err := s.monitorES.CheckIndiceLocked(context.Background(), "_all", nil, nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
@@ -40,20 +46,162 @@ func (s *CheckESTestSuite) TestCheckIndiceLocked() {
 		"bar",
 		checkES.client.API.Indices.Create.WithContext(context.Background()),
 	)
-	monitoringData, err = s.monitorES.CheckIndiceLocked("bar")
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "bar", nil, nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When check indice that not exist
-	monitoringData, err = s.monitorES.CheckIndiceLocked("foo")
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "foo", nil, nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
 
 	// When indice is locked and only one indice
-	monitoringData, err = s.monitorES.CheckIndiceLocked("lock")
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+
+	// When thresholds allow some indices locked
+	thresholds, err = NewThresholds("0", "5")
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When dry run auto unlock, indice stay locked
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, &IndiceUnlockOptions{DryRun: true})
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When auto unlock, indice is unlocked because disk is below high watermark
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, &IndiceUnlockOptions{})
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "lock", nil, nil, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When write block only raise warning
+	checkES.client.API.Indices.Create(
+		"write-lock",
+		checkES.client.API.Indices.Create.WithContext(context.Background()),
+		checkES.client.API.Indices.Create.WithBody(strings.NewReader(`
+			{
+				"settings": {
+					"index": {
+						"blocks": {
+							"write": true
+						}
+					}
+				}
+			}
+		`)),
+	)
+	thresholds, err = NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+	blocks, err := ParseIndiceBlocks([]string{"read_only_allow_delete=critical,write=warning"})
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "write-lock", nil, blocks, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When locked indice is excluded
+	indiceFilter, err := filter.New(nil, []string{"re:^write-"})
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "write-lock", indiceFilter, blocks, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When write block is ignored
+	blocks, err = ParseIndiceBlocks([]string{"write=ignore"})
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckIndiceLocked(context.Background(), "write-lock", nil, blocks, thresholds, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+}
+
+func TestUnlockIndices(t *testing.T) {
+
+	unlockedIndices := make([]string, 0)
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_cluster/settings":
+			w.Write([]byte(`{"persistent":{},"transient":{},"defaults":{}}`))
+		case strings.HasPrefix(r.URL.Path, "/_nodes/stats"):
+			w.Write([]byte(`{"nodes":{"id1":{"name":"node1","fs":{"total":{"total_in_bytes":100,"available_in_bytes":50}}}}}`))
+		case r.URL.Path == "/_cat/shards":
+			w.Write([]byte(`[{"index":"logs-1","shard":"0","prirep":"p","state":"STARTED","node":"node1"},{"index":"logs-2","shard":"0","prirep":"p","state":"UNASSIGNED","node":null}]`))
+		case r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, "/_settings"):
+			unlockedIndices = append(unlockedIndices, strings.Split(r.URL.Path, "/")[1])
+			w.Write([]byte(`{"acknowledged":true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitoringData := NewMonitoring()
+
+	// When indice has no assigned shards, it stay locked
+	err = monitorES.(*CheckES).unlockIndices(context.Background(), []string{"logs-1", "logs-2"}, &IndiceUnlockOptions{}, monitoringData)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"logs-1"}, unlockedIndices)
+	assert.Contains(t, monitoringData.Messages(), "Keep indice logs-2 locked: no assigned shards, not unlocked")
+}
+
+func TestCheckIndiceLockedAutoUnlockWithoutBlock(t *testing.T) {
+
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{}`))
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blocks, err := ParseIndiceBlocks([]string{"write=warning"})
+	assert.NoError(t, err)
+
+	// When auto unlock is set without read_only_allow_delete block
+	_, err = monitorES.CheckIndiceLocked(context.Background(), "_all", nil, blocks, nil, &IndiceUnlockOptions{})
+	assert.Error(t, err)
+
+	// When auto unlock is set with the default blocks
+	monitoringData, err := monitorES.CheckIndiceLocked(context.Background(), "_all", nil, nil, nil, &IndiceUnlockOptions{DryRun: true})
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+}
+
+func TestParseIndiceBlocks(t *testing.T) {
+
+	blocks, err := ParseIndiceBlocks([]string{"read_only_allow_delete=critical,write=warning", "read_only=ignore", "metadata"})
+	assert.NoError(t, err)
+	assert.Equal(t, []IndiceBlock{
+		{Name: "read_only_allow_delete", Severity: nagiosPlugin.STATUS_CRITICAL},
+		{Name: "write", Severity: nagiosPlugin.STATUS_WARNING},
+		{Name: "read_only", Severity: nagiosPlugin.STATUS_OK},
+		{Name: "metadata", Severity: nagiosPlugin.STATUS_CRITICAL},
+	}, blocks)
+
+	// When block not exist
+	_, err = ParseIndiceBlocks([]string{"foo=critical"})
+	assert.Error(t, err)
+
+	// When severity not exist
+	_, err = ParseIndiceBlocks([]string{"write=foo"})
+	assert.Error(t, err)
 }
@@ -0,0 +1,189 @@
+package checkes
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+)
+
+// Monitoring extend nagiosPlugin.Monitoring to write thresholds on perfdata
+type Monitoring struct {
+	*nagiosPlugin.Monitoring
+	thresholds map[string]*Thresholds
+}
+
+// MonitoringResult is the check result as structured document
+type MonitoringResult struct {
+	Check      string           `json:"check"`
+	Cluster    string           `json:"cluster,omitempty"`
+	Status     int              `json:"status"`
+	StatusName string           `json:"status_name"`
+	Summary    string           `json:"summary"`
+	LongOutput []string         `json:"long_output"`
+	Perfdatas  []PerfdataResult `json:"perfdata"`
+	Duration   float64          `json:"duration"`
+}
+
+// PerfdataResult is the perfdata as structured document
+type PerfdataResult struct {
+	Label    string `json:"label"`
+	Value    int    `json:"value"`
+	Unit     string `json:"unit,omitempty"`
+	Warning  string `json:"warning,omitempty"`
+	Critical string `json:"critical,omitempty"`
+	Min      *int   `json:"min,omitempty"`
+	Max      *int   `json:"max,omitempty"`
+}
+
+// NewMonitoring init the monitoring data
+func NewMonitoring() *Monitoring {
+	return &Monitoring{
+		Monitoring: nagiosPlugin.NewMonitoring(),
+		thresholds: make(map[string]*Thresholds),
+	}
+}
+
+// AddPerfdataWithThresholds add perfdata with the warning and critical thresholds
+func (m *Monitoring) AddPerfdataWithThresholds(label string, value int, unit string, thresholds *Thresholds) error {
+	if err := m.AddPerfdata(label, value, unit); err != nil {
+		return err
+	}
+	if thresholds != nil {
+		m.thresholds[label] = thresholds
+	}
+
+	return nil
+}
+
+// PerfdataThresholds return the thresholds of perfdata or nil if there are no thresholds
+func (m *Monitoring) PerfdataThresholds(label string) *Thresholds {
+	return m.thresholds[label]
+}
+
+// ToString return the Nagios output with thresholds on perfdata
+func (m *Monitoring) ToString() string {
+
+	var buffer bytes.Buffer
+
+	for idx, message := range m.Messages() {
+		if idx > 0 {
+			buffer.WriteString("\n")
+		}
+		buffer.WriteString(message)
+	}
+
+	if len(m.Perfdatas()) > 0 {
+		buffer.WriteString("|")
+		for _, perfdata := range m.Perfdatas() {
+			var warning, critical string
+			if thresholds := m.PerfdataThresholds(perfdata.Label()); thresholds != nil {
+				warning = thresholds.Warning.String()
+				critical = thresholds.Critical.String()
+			}
+			buffer.WriteString(fmt.Sprintf("%s=%d%s;%s;%s;; ", perfdata.Label(), perfdata.Value(), perfdata.Unit(), warning, critical))
+		}
+	}
+
+	return buffer.String()
+}
+
+// ToResult return the check result as structured document
+func (m *Monitoring) ToResult(checkName string) *MonitoringResult {
+
+	result := &MonitoringResult{
+		Check:      checkName,
+		Status:     m.Status(),
+		StatusName: m.StatusName(),
+		LongOutput: make([]string, 0),
+		Perfdatas:  make([]PerfdataResult, 0, len(m.Perfdatas())),
+	}
+
+	for idx, message := range m.Messages() {
+		if idx == 0 {
+			result.Summary = message
+		} else {
+			result.LongOutput = append(result.LongOutput, message)
+		}
+	}
+
+	for _, perfdata := range m.Perfdatas() {
+		perfdataResult := PerfdataResult{
+			Label: perfdata.Label(),
+			Value: perfdata.Value(),
+			Unit:  perfdata.Unit(),
+		}
+		if thresholds := m.PerfdataThresholds(perfdata.Label()); thresholds != nil {
+			perfdataResult.Warning = thresholds.Warning.String()
+			perfdataResult.Critical = thresholds.Critical.String()
+		}
+		// Percent is always between 0 and 100
+		if perfdata.Unit() == "%" {
+			min, max := 0, 100
+			perfdataResult.Min = &min
+			perfdataResult.Max = &max
+		}
+		result.Perfdatas = append(result.Perfdatas, perfdataResult)
+	}
+
+	return result
+}
+
+// Output return the Nagios output with the status
+func (m *Monitoring) Output() string {
+	return fmt.Sprintf("%s - %s", m.StatusName(), m.ToString())
+}
+
+// ToSdtOut print the Nagios output and exit with the status code
+func (m *Monitoring) ToSdtOut() {
+	fmt.Println(m.Output())
+	os.Exit(m.Status())
+}
+
+// StatusName return the Nagios status name
+func (m *Monitoring) StatusName() string {
+	switch m.Status() {
+	case nagiosPlugin.STATUS_OK:
+		return "OK"
+	case nagiosPlugin.STATUS_WARNING:
+		return "WARNING"
+	case nagiosPlugin.STATUS_CRITICAL:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// ParseStatus return the Nagios status from its name
+func ParseStatus(name string) (int, error) {
+	switch strings.ToUpper(name) {
+	case "OK":
+		return nagiosPlugin.STATUS_OK, nil
+	case "WARNING":
+		return nagiosPlugin.STATUS_WARNING, nil
+	case "CRITICAL":
+		return nagiosPlugin.STATUS_CRITICAL, nil
+	case "UNKNOWN":
+		return nagiosPlugin.STATUS_UNKNOWN, nil
+	default:
+		return nagiosPlugin.STATUS_UNKNOWN, errors.Errorf("Status %s is not supported", name)
+	}
+}
+
+// ParseSeverity return the maximum Nagios status from its name: `ignore`, `warning` or `critical`.
+// The severity `ignore` is the OK status. The severity `unknown` is not supported because the thresholds never raise it
+func ParseSeverity(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "ignore":
+		return nagiosPlugin.STATUS_OK, nil
+	case "warning":
+		return nagiosPlugin.STATUS_WARNING, nil
+	case "critical":
+		return nagiosPlugin.STATUS_CRITICAL, nil
+	default:
+		return nagiosPlugin.STATUS_UNKNOWN, errors.Errorf("Severity %s is not supported", name)
+	}
+}
@@ -0,0 +1,75 @@
+package checkes
+
+import (
+	"testing"
+
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMonitoringToString(t *testing.T) {
+
+	thresholds, err := NewThresholds("5", "10")
+	assert.NoError(t, err)
+
+	monitoringData := NewMonitoring()
+	monitoringData.AddMessage("test")
+	monitoringData.AddPerfdata("foo", 1, "")
+	monitoringData.AddPerfdataWithThresholds("bar", 2, "s", thresholds)
+
+	assert.Equal(t, thresholds, monitoringData.PerfdataThresholds("bar"))
+	assert.Nil(t, monitoringData.PerfdataThresholds("foo"))
+	assert.Equal(t, "test|foo=1;;;; bar=2s;5;10;; ", monitoringData.ToString())
+}
+
+func TestMonitoringToResult(t *testing.T) {
+
+	thresholds, err := NewThresholds("5", "10")
+	assert.NoError(t, err)
+
+	monitoringData := NewMonitoring()
+	monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+	monitoringData.AddMessage("summary")
+	monitoringData.AddMessage("detail")
+	monitoringData.AddPerfdataWithThresholds("foo", 6, "", thresholds)
+	monitoringData.AddPerfdata("bar", 50, "%")
+
+	result := monitoringData.ToResult("check-foo")
+	assert.Equal(t, "check-foo", result.Check)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, result.Status)
+	assert.Equal(t, "WARNING", result.StatusName)
+	assert.Equal(t, "summary", result.Summary)
+	assert.Equal(t, []string{"detail"}, result.LongOutput)
+	assert.Len(t, result.Perfdatas, 2)
+	assert.Equal(t, "5", result.Perfdatas[0].Warning)
+	assert.Equal(t, "10", result.Perfdatas[0].Critical)
+	assert.Nil(t, result.Perfdatas[0].Min)
+	assert.Equal(t, 100, *result.Perfdatas[1].Max)
+}
+
+func TestParseStatus(t *testing.T) {
+	status, err := ParseStatus("critical")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, status)
+
+	status, err = ParseStatus("UNKNOWN")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_UNKNOWN, status)
+
+	_, err = ParseStatus("foo")
+	assert.Error(t, err)
+}
+
+func TestParseSeverity(t *testing.T) {
+	status, err := ParseSeverity("ignore")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, status)
+
+	status, err = ParseSeverity("Warning")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, status)
+
+	// When severity is unknown, it is rejected because thresholds never raise it
+	_, err = ParseSeverity("unknown")
+	assert.Error(t, err)
+}
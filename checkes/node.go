@@ -0,0 +1,717 @@
+package checkes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+const (
+	diskWatermarkLowSetting        = "cluster.routing.allocation.disk.watermark.low"
+	diskWatermarkHighSetting       = "cluster.routing.allocation.disk.watermark.high"
+	diskWatermarkFloodStageSetting = "cluster.routing.allocation.disk.watermark.flood_stage"
+)
+
+// NodesStatsResponse is the API response
+type NodesStatsResponse struct {
+	Nodes map[string]NodeStats `json:"nodes"`
+}
+
+// NodeStats is the API response
+type NodeStats struct {
+	Name       string                         `json:"name"`
+	Host       string                         `json:"host"`
+	Roles      []string                       `json:"roles,omitempty"`
+	FS         *NodeFSStats                   `json:"fs,omitempty"`
+	JVM        *NodeJVMStats                  `json:"jvm,omitempty"`
+	Breakers   map[string]NodeBreakerStats    `json:"breakers,omitempty"`
+	ThreadPool map[string]NodeThreadPoolStats `json:"thread_pool,omitempty"`
+}
+
+// NodeFSStats is the API response
+type NodeFSStats struct {
+	Total NodeFSTotalStats `json:"total"`
+}
+
+// NodeFSTotalStats is the API response
+type NodeFSTotalStats struct {
+	TotalInBytes     int64 `json:"total_in_bytes"`
+	FreeInBytes      int64 `json:"free_in_bytes"`
+	AvailableInBytes int64 `json:"available_in_bytes"`
+}
+
+// NodeJVMStats is the API response
+type NodeJVMStats struct {
+	Mem NodeJVMMemStats `json:"mem"`
+	GC  NodeJVMGCStats  `json:"gc"`
+}
+
+// NodeJVMMemStats is the API response
+type NodeJVMMemStats struct {
+	HeapUsedInBytes int64 `json:"heap_used_in_bytes"`
+	HeapUsedPercent int   `json:"heap_used_percent"`
+	HeapMaxInBytes  int64 `json:"heap_max_in_bytes"`
+}
+
+// NodeJVMGCStats is the API response
+type NodeJVMGCStats struct {
+	Collectors map[string]NodeJVMGCCollectorStats `json:"collectors"`
+}
+
+// NodeJVMGCCollectorStats is the API response
+type NodeJVMGCCollectorStats struct {
+	CollectionCount        int64 `json:"collection_count"`
+	CollectionTimeInMillis int64 `json:"collection_time_in_millis"`
+}
+
+// NodeBreakerStats is the API response
+type NodeBreakerStats struct {
+	LimitSizeInBytes     int64   `json:"limit_size_in_bytes"`
+	EstimatedSizeInBytes int64   `json:"estimated_size_in_bytes"`
+	Overhead             float64 `json:"overhead"`
+	Tripped              int64   `json:"tripped"`
+}
+
+// NodeThreadPoolStats is the API response
+type NodeThreadPoolStats struct {
+	Threads   int   `json:"threads"`
+	Queue     int   `json:"queue"`
+	Active    int   `json:"active"`
+	Rejected  int64 `json:"rejected"`
+	Largest   int   `json:"largest"`
+	Completed int64 `json:"completed"`
+}
+
+// NodeThreadPoolState is the thread pool rejected counters stored between two runs
+type NodeThreadPoolState struct {
+	Timestamp time.Time                   `json:"timestamp"`
+	Nodes     map[string]map[string]int64 `json:"nodes"`
+}
+
+// NodeJVMState is the JVM sample stored between two runs
+type NodeJVMState struct {
+	Timestamp time.Time                `json:"timestamp"`
+	Nodes     map[string]NodeJVMSample `json:"nodes"`
+}
+
+// NodeJVMSample is the JVM counters of node
+type NodeJVMSample struct {
+	OldGCCount        int64            `json:"old_gc_count"`
+	OldGCTimeInMillis int64            `json:"old_gc_time_in_millis"`
+	BreakersTripped   map[string]int64 `json:"breakers_tripped"`
+}
+
+// ClusterSettingsResponse is the API response with flat settings
+type ClusterSettingsResponse struct {
+	Persistent map[string]interface{} `json:"persistent"`
+	Transient  map[string]interface{} `json:"transient"`
+	Defaults   map[string]interface{} `json:"defaults,omitempty"`
+}
+
+// DiskWatermark is a disk watermark setting. It can be a used percent or a minimum free space in bytes
+type DiskWatermark struct {
+	raw       string
+	isPercent bool
+	percent   float64
+	bytes     int64
+}
+
+// DiskWatermarks is the effective disk watermarks of the cluster
+type DiskWatermarks struct {
+	Low        *DiskWatermark
+	High       *DiskWatermark
+	FloodStage *DiskWatermark
+}
+
+// IsDataNode return true if node can hold some shards
+func (n NodeStats) IsDataNode() bool {
+	// Roles is not provided on old cluster
+	if n.Roles == nil {
+		return true
+	}
+	for _, role := range n.Roles {
+		if strings.HasPrefix(role, "data") {
+			return true
+		}
+	}
+	return false
+}
+
+// UsedPercent return the disk used percent of the node
+func (s NodeFSTotalStats) UsedPercent() float64 {
+	if s.TotalInBytes == 0 {
+		return 0
+	}
+	return float64(s.TotalInBytes-s.AvailableInBytes) / float64(s.TotalInBytes) * 100
+}
+
+// CheckNodeDisk wrap command line to check
+func CheckNodeDisk(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckNodeDisk(c.Context, thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckNodeDisk check the disk usage of data nodes against the cluster disk watermarks
+// It return warning when low or high watermark is exceeded and critical when flood stage watermark is exceeded.
+// When thresholds are set, they replace the watermarks and are applied on the disk used percent
+func (h *CheckES) CheckNodeDisk(ctx context.Context, thresholds *Thresholds) (*Monitoring, error) {
+
+	log.Debugf("Thresholds: %+v", thresholds)
+	monitoringData := NewMonitoring()
+
+	diskWatermarks, err := h.getDiskWatermarks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Disk watermarks: low=%s, high=%s, flood_stage=%s", diskWatermarks.Low, diskWatermarks.High, diskWatermarks.FloodStage)
+
+	nodesStats, err := h.getNodesStats(ctx, "fs")
+	if err != nil {
+		return nil, err
+	}
+
+	// Set thresholds on perfdata when watermarks are percent
+	if !thresholds.IsSet() && diskWatermarks.Low.isPercent && diskWatermarks.FloodStage.isPercent {
+		thresholds, err = NewThresholds(fmt.Sprintf("%g", diskWatermarks.Low.percent), fmt.Sprintf("%g", diskWatermarks.FloodStage.percent))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	nbNode := 0
+	brokenNodes := make([]string, 0)
+	for _, nodeID := range sortedNodeIDs(nodesStats) {
+		nodeStats := nodesStats.Nodes[nodeID]
+		if !nodeStats.IsDataNode() || nodeStats.FS == nil {
+			log.Debugf("Node %s is not data node", nodeStats.Name)
+			continue
+		}
+		nbNode++
+		fsStats := nodeStats.FS.Total
+
+		switch {
+		case thresholds.IsSet():
+			switch thresholds.Status(fsStats.UsedPercent()) {
+			case nagiosPlugin.STATUS_CRITICAL:
+				monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+				brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s exceed critical threshold %s: %.1f%% used (%s available)", nodeStats.Name, thresholds.Critical, fsStats.UsedPercent(), formatByteSize(fsStats.AvailableInBytes)))
+			case nagiosPlugin.STATUS_WARNING:
+				monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+				brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s exceed warning threshold %s: %.1f%% used (%s available)", nodeStats.Name, thresholds.Warning, fsStats.UsedPercent(), formatByteSize(fsStats.AvailableInBytes)))
+			}
+		case diskWatermarks.FloodStage.IsExceeded(fsStats):
+			monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s exceed flood stage watermark %s: %.1f%% used (%s available)", nodeStats.Name, diskWatermarks.FloodStage, fsStats.UsedPercent(), formatByteSize(fsStats.AvailableInBytes)))
+		case diskWatermarks.High.IsExceeded(fsStats):
+			monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s exceed high watermark %s: %.1f%% used (%s available)", nodeStats.Name, diskWatermarks.High, fsStats.UsedPercent(), formatByteSize(fsStats.AvailableInBytes)))
+		case diskWatermarks.Low.IsExceeded(fsStats):
+			monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s exceed low watermark %s: %.1f%% used (%s available)", nodeStats.Name, diskWatermarks.Low, fsStats.UsedPercent(), formatByteSize(fsStats.AvailableInBytes)))
+		}
+
+		monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_diskUsed", nodeStats.Name), int(math.Round(fsStats.UsedPercent())), "%", thresholds)
+	}
+
+	limits := "disk watermarks"
+	if thresholds.IsSet() {
+		limits = "disk thresholds"
+	}
+	if len(brokenNodes) > 0 {
+		monitoringData.AddMessage("Some nodes exceed %s (%d/%d)", limits, nbNode-len(brokenNodes), nbNode)
+		for _, brokenNode := range brokenNodes {
+			monitoringData.AddMessage(brokenNode)
+		}
+	} else {
+		monitoringData.AddMessage("All nodes are under %s (%d/%d)", limits, nbNode, nbNode)
+	}
+
+	return monitoringData, nil
+}
+
+// CheckNodeJVM wrap command line to check
+func CheckNodeJVM(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	heapThresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+	gcRateThresholds, err := NewThresholds(c.String("warning-gc-rate"), c.String("critical-gc-rate"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse GC rate thresholds")
+	}
+	breakerThresholds, err := NewThresholds(c.String("warning-breaker-tripped"), c.String("critical-breaker-tripped"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse breaker tripped thresholds")
+	}
+
+	monitoringData, err := monitorES.CheckNodeJVM(c.Context, heapThresholds, gcRateThresholds, breakerThresholds, manageStateFileParameter(c, "node-jvm"))
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckNodeJVM check the JVM heap usage, the old GC and the circuit breakers of each node.
+// The heap thresholds are applied on the heap used percent.
+// The GC and breaker counters are cumulative, so the previous sample is stored on state file and
+// the GC rate thresholds are applied on the number of old GC per minute and the breaker thresholds on the number of tripped breakers since the last run.
+func (h *CheckES) CheckNodeJVM(ctx context.Context, heapThresholds *Thresholds, gcRateThresholds *Thresholds, breakerThresholds *Thresholds, stateFile string) (*Monitoring, error) {
+
+	log.Debugf("StateFile: %s", stateFile)
+	monitoringData := NewMonitoring()
+
+	// The state file is locked until the new state is saved, so concurrent checks not lose samples
+	unlock := lockStateFile(stateFile)
+	defer unlock()
+	previousState := &NodeJVMState{}
+	if err := loadState(stateFile, previousState); err != nil {
+		return nil, err
+	}
+
+	nodesStats, err := h.getNodesStats(ctx, "jvm", "breaker")
+	if err != nil {
+		return nil, err
+	}
+
+	currentState := &NodeJVMState{
+		Timestamp: time.Now(),
+		Nodes:     make(map[string]NodeJVMSample, len(nodesStats.Nodes)),
+	}
+	elapsedMinutes := currentState.Timestamp.Sub(previousState.Timestamp).Minutes()
+
+	nbNode := 0
+	brokenNodes := make([]string, 0)
+	for _, nodeID := range sortedNodeIDs(nodesStats) {
+		nodeStats := nodesStats.Nodes[nodeID]
+		if nodeStats.JVM == nil {
+			continue
+		}
+		nbNode++
+
+		// Compute the current sample
+		oldGC := nodeStats.JVM.GC.Collectors["old"]
+		sample := NodeJVMSample{
+			OldGCCount:        oldGC.CollectionCount,
+			OldGCTimeInMillis: oldGC.CollectionTimeInMillis,
+			BreakersTripped:   make(map[string]int64, len(nodeStats.Breakers)),
+		}
+		var nbBreakerTripped int64
+		for name, breaker := range nodeStats.Breakers {
+			sample.BreakersTripped[name] = breaker.Tripped
+			nbBreakerTripped += breaker.Tripped
+		}
+		currentState.Nodes[nodeID] = sample
+
+		// Check heap
+		status := heapThresholds.Status(float64(nodeStats.JVM.Mem.HeapUsedPercent))
+		if status != nagiosPlugin.STATUS_OK {
+			monitoringData.SetStatus(status)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s use %d%% of heap (%s/%s)", nodeStats.Name, nodeStats.JVM.Mem.HeapUsedPercent, formatByteSize(nodeStats.JVM.Mem.HeapUsedInBytes), formatByteSize(nodeStats.JVM.Mem.HeapMaxInBytes)))
+		}
+		monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_heapUsed", nodeStats.Name), nodeStats.JVM.Mem.HeapUsedPercent, "%", heapThresholds)
+		monitoringData.AddPerfdata(fmt.Sprintf("%s_oldGCCount", nodeStats.Name), int(oldGC.CollectionCount), "c")
+		monitoringData.AddPerfdata(fmt.Sprintf("%s_oldGCTime", nodeStats.Name), int(oldGC.CollectionTimeInMillis), "ms")
+		monitoringData.AddPerfdata(fmt.Sprintf("%s_breakersTripped", nodeStats.Name), int(nbBreakerTripped), "c")
+
+		// Check rates since the last run. Counters are reset when node restart
+		previousSample, ok := previousState.Nodes[nodeID]
+		if !ok || elapsedMinutes <= 0 || previousSample.OldGCCount > sample.OldGCCount {
+			log.Debugf("No previous sample for node %s", nodeStats.Name)
+			continue
+		}
+
+		gcRate := float64(sample.OldGCCount-previousSample.OldGCCount) / elapsedMinutes
+		status = gcRateThresholds.Status(gcRate)
+		if status != nagiosPlugin.STATUS_OK {
+			monitoringData.SetStatus(status)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s run %.1f old GC per minute (%dms)", nodeStats.Name, gcRate, sample.OldGCTimeInMillis-previousSample.OldGCTimeInMillis))
+		}
+		monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_oldGCRate", nodeStats.Name), int(math.Round(gcRate)), "", gcRateThresholds)
+
+		var nbBreakerTrippedSinceLastRun int64
+		breakersTripped := make([]string, 0)
+		breakerNames := make([]string, 0, len(sample.BreakersTripped))
+		for name := range sample.BreakersTripped {
+			breakerNames = append(breakerNames, name)
+		}
+		sort.Strings(breakerNames)
+		for _, name := range breakerNames {
+			delta := sample.BreakersTripped[name] - previousSample.BreakersTripped[name]
+			if delta < 0 {
+				delta = 0
+			}
+			if delta > 0 {
+				nbBreakerTrippedSinceLastRun += delta
+				breakersTripped = append(breakersTripped, fmt.Sprintf("%s (%d)", name, delta))
+			}
+			monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_breaker_%s_tripped", nodeStats.Name, name), int(delta), "", breakerThresholds)
+		}
+		status = breakerThresholds.Status(float64(nbBreakerTrippedSinceLastRun))
+		if status != nagiosPlugin.STATUS_OK {
+			monitoringData.SetStatus(status)
+			brokenNodes = append(brokenNodes, fmt.Sprintf("Node %s tripped %d circuit breakers since last run: %s", nodeStats.Name, nbBreakerTrippedSinceLastRun, strings.Join(breakersTripped, ", ")))
+		}
+	}
+
+	if err = saveState(stateFile, currentState); err != nil {
+		return nil, err
+	}
+
+	if len(brokenNodes) > 0 {
+		monitoringData.AddMessage("Some nodes have JVM issues")
+		for _, brokenNode := range brokenNodes {
+			monitoringData.AddMessage(brokenNode)
+		}
+	} else {
+		monitoringData.AddMessage("All nodes JVM are ok (%d/%d)", nbNode, nbNode)
+	}
+
+	return monitoringData, nil
+}
+
+// CheckThreadPool wrap command line to check
+func CheckThreadPool(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	if len(c.StringSlice("pool")) == 0 {
+		return errors.New("You must set --pool parameter")
+	}
+
+	queueThresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+	rejectedThresholds, err := NewThresholds(c.String("warning-rejected"), c.String("critical-rejected"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse rejected thresholds")
+	}
+
+	pools := append([]string{}, c.StringSlice("pool")...)
+	sort.Strings(pools)
+
+	monitoringData, err := monitorES.CheckThreadPool(c.Context, c.StringSlice("pool"), queueThresholds, rejectedThresholds, manageStateFileParameter(c, "thread-pool", strings.Join(pools, ",")))
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckThreadPool check the queue size and the rejections of thread pools on each node.
+// The queue thresholds are applied on the queue size and the rejected thresholds on the number of rejections since the last run.
+// The rejected counters are cumulative, so the previous sample is stored on state file.
+func (h *CheckES) CheckThreadPool(ctx context.Context, pools []string, queueThresholds *Thresholds, rejectedThresholds *Thresholds, stateFile string) (*Monitoring, error) {
+
+	if len(pools) == 0 {
+		return nil, errors.New("Pools can't be empty")
+	}
+	log.Debugf("Pools: %+v", pools)
+	log.Debugf("StateFile: %s", stateFile)
+	monitoringData := NewMonitoring()
+
+	// The state file is locked until the new state is saved, so concurrent checks not lose samples
+	unlock := lockStateFile(stateFile)
+	defer unlock()
+	previousState := &NodeThreadPoolState{}
+	if err := loadState(stateFile, previousState); err != nil {
+		return nil, err
+	}
+
+	nodesStats, err := h.getNodesStats(ctx, "thread_pool")
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep the samples of other pools and nodes, in case where state file is shared with other checks
+	currentState := &NodeThreadPoolState{
+		Timestamp: time.Now(),
+		Nodes:     make(map[string]map[string]int64, len(nodesStats.Nodes)),
+	}
+	for nodeID, previousSample := range previousState.Nodes {
+		currentState.Nodes[nodeID] = make(map[string]int64, len(previousSample))
+		for pool, rejected := range previousSample {
+			currentState.Nodes[nodeID][pool] = rejected
+		}
+	}
+
+	brokenPools := make([]string, 0)
+	for _, nodeID := range sortedNodeIDs(nodesStats) {
+		nodeStats := nodesStats.Nodes[nodeID]
+		if _, ok := currentState.Nodes[nodeID]; !ok {
+			currentState.Nodes[nodeID] = make(map[string]int64, len(pools))
+		}
+		previousSample, hasPreviousSample := previousState.Nodes[nodeID]
+
+		for _, pool := range pools {
+			poolStats, ok := nodeStats.ThreadPool[pool]
+			if !ok {
+				log.Debugf("Thread pool %s not found on node %s", pool, nodeStats.Name)
+				continue
+			}
+			currentState.Nodes[nodeID][pool] = poolStats.Rejected
+
+			// Check queue
+			status := queueThresholds.Status(float64(poolStats.Queue))
+			if status != nagiosPlugin.STATUS_OK {
+				monitoringData.SetStatus(status)
+				brokenPools = append(brokenPools, fmt.Sprintf("Thread pool %s on node %s has %d tasks on queue (%d/%d threads active)", pool, nodeStats.Name, poolStats.Queue, poolStats.Active, poolStats.Threads))
+			}
+			monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_%s_queue", nodeStats.Name, pool), poolStats.Queue, "", queueThresholds)
+			monitoringData.AddPerfdata(fmt.Sprintf("%s_%s_active", nodeStats.Name, pool), poolStats.Active, "")
+			monitoringData.AddPerfdata(fmt.Sprintf("%s_%s_rejected", nodeStats.Name, pool), int(poolStats.Rejected), "c")
+
+			// Check rejections since the last run. Counters are reset when node restart
+			previousRejected, ok := previousSample[pool]
+			if !hasPreviousSample || !ok || previousRejected > poolStats.Rejected {
+				log.Debugf("No previous sample for thread pool %s on node %s", pool, nodeStats.Name)
+				continue
+			}
+			rejected := poolStats.Rejected - previousRejected
+			status = rejectedThresholds.Status(float64(rejected))
+			if status != nagiosPlugin.STATUS_OK {
+				monitoringData.SetStatus(status)
+				brokenPools = append(brokenPools, fmt.Sprintf("Thread pool %s on node %s rejected %d tasks since last run", pool, nodeStats.Name, rejected))
+			}
+			monitoringData.AddPerfdataWithThresholds(fmt.Sprintf("%s_%s_rejectedSinceLastRun", nodeStats.Name, pool), int(rejected), "", rejectedThresholds)
+		}
+	}
+
+	if err = saveState(stateFile, currentState); err != nil {
+		return nil, err
+	}
+
+	if len(brokenPools) > 0 {
+		monitoringData.AddMessage("Some thread pools are overloaded")
+		for _, brokenPool := range brokenPools {
+			monitoringData.AddMessage(brokenPool)
+		}
+	} else {
+		monitoringData.AddMessage("All thread pools %s are ok", strings.Join(pools, ","))
+	}
+
+	return monitoringData, nil
+}
+
+// sortedNodeIDs return the node IDs sorted by node name to have always the same output
+func sortedNodeIDs(nodesStats *NodesStatsResponse) []string {
+	nodeIDs := make([]string, 0, len(nodesStats.Nodes))
+	for nodeID := range nodesStats.Nodes {
+		nodeIDs = append(nodeIDs, nodeID)
+	}
+	sort.Slice(nodeIDs, func(i, j int) bool {
+		return nodesStats.Nodes[nodeIDs[i]].Name < nodesStats.Nodes[nodeIDs[j]].Name
+	})
+
+	return nodeIDs
+}
+
+// getNodesStats return the nodes stats for the given metrics
+func (h *CheckES) getNodesStats(ctx context.Context, metrics ...string) (*NodesStatsResponse, error) {
+
+	res, err := h.client.API.Nodes.Stats(
+		h.client.API.Nodes.Stats.WithContext(ctx),
+		h.client.API.Nodes.Stats.WithMetric(metrics...),
+		h.client.API.Nodes.Stats.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get nodes stats %s", strings.Join(metrics, ","))
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get nodes stats %s: %s", strings.Join(metrics, ","), res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get nodes stats %s successfully:\n%s", strings.Join(metrics, ","), string(b))
+	nodesStatsResponse := &NodesStatsResponse{}
+	err = json.Unmarshal(b, nodesStatsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return nodesStatsResponse, nil
+}
+
+// getDiskWatermarks return the effective disk watermarks of the cluster
+func (h *CheckES) getDiskWatermarks(ctx context.Context) (*DiskWatermarks, error) {
+
+	res, err := h.client.API.Cluster.GetSettings(
+		h.client.API.Cluster.GetSettings.WithContext(ctx),
+		h.client.API.Cluster.GetSettings.WithIncludeDefaults(true),
+		h.client.API.Cluster.GetSettings.WithFlatSettings(true),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when get cluster settings")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get cluster settings: %s", res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get cluster settings successfully:\n%s", string(b))
+	clusterSettingsResponse := &ClusterSettingsResponse{}
+	err = json.Unmarshal(b, clusterSettingsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	diskWatermarks := &DiskWatermarks{}
+	if diskWatermarks.Low, err = parseDiskWatermark(clusterSettingsResponse.Setting(diskWatermarkLowSetting, "85%")); err != nil {
+		return nil, err
+	}
+	if diskWatermarks.High, err = parseDiskWatermark(clusterSettingsResponse.Setting(diskWatermarkHighSetting, "90%")); err != nil {
+		return nil, err
+	}
+	if diskWatermarks.FloodStage, err = parseDiskWatermark(clusterSettingsResponse.Setting(diskWatermarkFloodStageSetting, "95%")); err != nil {
+		return nil, err
+	}
+
+	return diskWatermarks, nil
+}
+
+// Setting return the effective value of flat setting. Transient setting take precedence on persistent setting that take precedence on default setting.
+func (r *ClusterSettingsResponse) Setting(name string, defaultValue string) string {
+	for _, settings := range []map[string]interface{}{r.Transient, r.Persistent, r.Defaults} {
+		if value, ok := settings[name]; ok {
+			if v, ok := value.(string); ok {
+				return v
+			}
+		}
+	}
+	return defaultValue
+}
+
+// parseDiskWatermark parse disk watermark like `85%`, `0.85` or `10gb`
+func parseDiskWatermark(rawWatermark string) (*DiskWatermark, error) {
+
+	watermark := &DiskWatermark{
+		raw: rawWatermark,
+	}
+	value := strings.TrimSpace(rawWatermark)
+
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.ParseFloat(strings.TrimSuffix(value, "%"), 64)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when parse disk watermark %s", rawWatermark)
+		}
+		watermark.isPercent = true
+		watermark.percent = percent
+		return watermark, nil
+	}
+
+	// Ratio
+	if ratio, err := strconv.ParseFloat(value, 64); err == nil {
+		watermark.isPercent = true
+		watermark.percent = ratio * 100
+		return watermark, nil
+	}
+
+	bytes, err := parseByteSize(value)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when parse disk watermark %s", rawWatermark)
+	}
+	watermark.bytes = bytes
+
+	return watermark, nil
+}
+
+// IsExceeded return true if the disk usage exceed the watermark
+func (w *DiskWatermark) IsExceeded(fsStats NodeFSTotalStats) bool {
+	if w.isPercent {
+		return fsStats.UsedPercent() >= w.percent
+	}
+	return fsStats.AvailableInBytes <= w.bytes
+}
+
+// String return the watermark as set on cluster
+func (w *DiskWatermark) String() string {
+	return w.raw
+}
+
+// parseByteSize parse Elasticsearch byte size like `500mb` or `1.5gb`
+func parseByteSize(rawSize string) (int64, error) {
+
+	value := strings.ToLower(strings.TrimSpace(rawSize))
+	units := []struct {
+		suffix     string
+		multiplier float64
+	}{
+		{"pb", math.Pow(1024, 5)},
+		{"tb", math.Pow(1024, 4)},
+		{"gb", math.Pow(1024, 3)},
+		{"mb", math.Pow(1024, 2)},
+		{"kb", 1024},
+		{"p", math.Pow(1024, 5)},
+		{"t", math.Pow(1024, 4)},
+		{"g", math.Pow(1024, 3)},
+		{"m", math.Pow(1024, 2)},
+		{"k", 1024},
+		{"b", 1},
+	}
+
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			size, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, unit.suffix)), 64)
+			if err != nil {
+				return 0, errors.Wrapf(err, "Error when parse byte size %s", rawSize)
+			}
+			return int64(size * unit.multiplier), nil
+		}
+	}
+
+	return 0, errors.Errorf("Byte size %s must have unit", rawSize)
+}
+
+// formatByteSize return human readable byte size
+func formatByteSize(size int64) string {
+	units := []string{"b", "kb", "mb", "gb", "tb", "pb"}
+	value := float64(size)
+	idx := 0
+	for value >= 1024 && idx < len(units)-1 {
+		value /= 1024
+		idx++
+	}
+	return fmt.Sprintf("%.1f%s", value, units[idx])
+}
@@ -0,0 +1,274 @@
+package checkes
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *CheckESTestSuite) TestCheckNodeDisk() {
+
+	// When check node disk
+	monitoringData, err := s.monitorES.CheckNodeDisk(context.Background(), nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.NotEqual(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+	assert.NotEmpty(s.T(), monitoringData.Perfdatas())
+}
+
+func (s *CheckESTestSuite) TestCheckNodeJVM() {
+
+	stateFile := filepath.Join(s.T().TempDir(), "state.json")
+	heapThresholds, err := NewThresholds("", "100")
+	assert.NoError(s.T(), err)
+	breakerThresholds, err := NewThresholds("0", "")
+	assert.NoError(s.T(), err)
+
+	// When there are no previous sample
+	monitoringData, err := s.monitorES.CheckNodeJVM(context.Background(), heapThresholds, nil, breakerThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.FileExists(s.T(), stateFile)
+
+	// When there are previous sample
+	monitoringData, err = s.monitorES.CheckNodeJVM(context.Background(), heapThresholds, nil, breakerThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.Contains(s.T(), monitoringData.ToString(), "_breaker_parent_tripped=0;0;;;")
+
+	// When heap threshold is raised
+	heapThresholds, err = NewThresholds("", "@0:100")
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckNodeJVM(context.Background(), heapThresholds, nil, breakerThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+}
+
+func TestCheckNodeJVMBreakerTripped(t *testing.T) {
+
+	tripped := 0
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"nodes":{"node1":{"name":"node1","jvm":{"mem":{"heap_used_percent":50},"gc":{"collectors":{"old":{"collection_count":1}}}},"breakers":{"parent":{"tripped":0},"request":{"tripped":%d}}}}}`, tripped)
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	breakerThresholds, err := NewThresholds("0", "5")
+	assert.NoError(t, err)
+
+	// When there are no previous sample
+	monitoringData, err := monitorES.CheckNodeJVM(context.Background(), nil, nil, breakerThresholds, stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.NotContains(t, monitoringData.ToString(), "_tripped=")
+
+	// When one breaker tripped since the last run
+	tripped = 2
+	monitoringData, err = monitorES.CheckNodeJVM(context.Background(), nil, nil, breakerThresholds, stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "node1_breaker_parent_tripped=0;0;5;;")
+	assert.Contains(t, monitoringData.ToString(), "node1_breaker_request_tripped=2;0;5;;")
+	assert.Contains(t, monitoringData.Messages(), "Node node1 tripped 2 circuit breakers since last run: request (2)")
+}
+
+func (s *CheckESTestSuite) TestCheckThreadPool() {
+
+	stateFile := filepath.Join(s.T().TempDir(), "state.json")
+	rejectedThresholds, err := NewThresholds("0", "")
+	assert.NoError(s.T(), err)
+
+	// When there are no previous sample
+	monitoringData, err := s.monitorES.CheckThreadPool(context.Background(), []string{"write", "search"}, nil, rejectedThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.FileExists(s.T(), stateFile)
+
+	// When there are previous sample
+	monitoringData, err = s.monitorES.CheckThreadPool(context.Background(), []string{"write", "search"}, nil, rejectedThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When queue threshold is raised
+	queueThresholds, err := NewThresholds("@0:", "")
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckThreadPool(context.Background(), []string{"write"}, queueThresholds, rejectedThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When pools is empty
+	_, err = s.monitorES.CheckThreadPool(context.Background(), []string{}, nil, rejectedThresholds, stateFile)
+	assert.Error(s.T(), err)
+}
+
+func TestCheckThreadPoolSharedStateFile(t *testing.T) {
+
+	rejected := 0
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"nodes":{"node1":{"name":"node1","thread_pool":{"write":{"rejected":%d},"search":{"rejected":0}}}}}`, rejected)
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	rejectedThresholds, err := NewThresholds("0", "")
+	assert.NoError(t, err)
+
+	// When there are no previous sample
+	monitoringData, err := monitorES.CheckThreadPool(context.Background(), []string{"write"}, nil, rejectedThresholds, stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When other pool is checked with the same state file, the write pool sample is kept
+	rejected = 5
+	monitoringData, err = monitorES.CheckThreadPool(context.Background(), []string{"search"}, nil, rejectedThresholds, stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	monitoringData, err = monitorES.CheckThreadPool(context.Background(), []string{"write"}, nil, rejectedThresholds, stateFile)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+}
+
+func TestCheckThreadPoolConcurrently(t *testing.T) {
+
+	pools := make([]string, 0, 10)
+	for i := 0; i < 10; i++ {
+		pools = append(pools, fmt.Sprintf("pool%d", i))
+	}
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		threadPools := make([]string, 0, len(pools))
+		for _, pool := range pools {
+			threadPools = append(threadPools, fmt.Sprintf(`"%s":{"rejected":0}`, pool))
+		}
+		fmt.Fprintf(w, `{"nodes":{"node1":{"name":"node1","thread_pool":{%s}}}}`, strings.Join(threadPools, ","))
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+
+	// When checks of other pools run concurrently on the same state file, no sample is lost
+	errs := make(chan error, len(pools))
+	wg := &sync.WaitGroup{}
+	for _, pool := range pools {
+		wg.Add(1)
+		go func(pool string) {
+			defer wg.Done()
+			_, err := monitorES.CheckThreadPool(context.Background(), []string{pool}, nil, nil, stateFile)
+			errs <- err
+		}(pool)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	state := &NodeThreadPoolState{}
+	assert.NoError(t, loadState(stateFile, state))
+	assert.Len(t, state.Nodes["node1"], len(pools))
+}
+
+func TestParseDiskWatermark(t *testing.T) {
+
+	fsStats := NodeFSTotalStats{
+		TotalInBytes:     100 * 1024 * 1024 * 1024,
+		AvailableInBytes: 10 * 1024 * 1024 * 1024,
+	}
+
+	// When watermark is percent
+	watermark, err := parseDiskWatermark("85%")
+	assert.NoError(t, err)
+	assert.True(t, watermark.IsExceeded(fsStats))
+	watermark, err = parseDiskWatermark("95%")
+	assert.NoError(t, err)
+	assert.False(t, watermark.IsExceeded(fsStats))
+
+	// When watermark is ratio
+	watermark, err = parseDiskWatermark("0.85")
+	assert.NoError(t, err)
+	assert.True(t, watermark.IsExceeded(fsStats))
+
+	// When watermark is absolute
+	watermark, err = parseDiskWatermark("20gb")
+	assert.NoError(t, err)
+	assert.True(t, watermark.IsExceeded(fsStats))
+	watermark, err = parseDiskWatermark("500mb")
+	assert.NoError(t, err)
+	assert.False(t, watermark.IsExceeded(fsStats))
+
+	// When watermark is wrong
+	_, err = parseDiskWatermark("foo")
+	assert.Error(t, err)
+}
+
+func TestParseByteSize(t *testing.T) {
+
+	size, err := parseByteSize("10b")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+
+	size, err = parseByteSize("1.5kb")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1536), size)
+
+	size, err = parseByteSize("2g")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2*1024*1024*1024), size)
+
+	_, err = parseByteSize("10")
+	assert.Error(t, err)
+}
+
+func TestCheckNodeDiskThresholds(t *testing.T) {
+
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/_cluster/settings":
+			w.Write([]byte(`{"persistent":{},"transient":{},"defaults":{}}`))
+		default:
+			w.Write([]byte(`{"nodes":{"id1":{"name":"node1","roles":["data"],"fs":{"total":{"total_in_bytes":100,"available_in_bytes":20}}}}}`))
+		}
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When thresholds are not set, the watermarks are used
+	monitoringData, err := monitorES.CheckNodeDisk(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "node1_diskUsed=80%;85;95;;")
+
+	// When thresholds are set, they replace the watermarks
+	thresholds, err := NewThresholds("70", "90")
+	assert.NoError(t, err)
+	monitoringData, err = monitorES.CheckNodeDisk(context.Background(), thresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "node1_diskUsed=80%;70;90;;")
+	assert.Equal(t, "Some nodes exceed disk thresholds (0/1)", monitoringData.Messages()[0])
+}
@@ -0,0 +1,185 @@
+package checkes
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// RepositoriesResponse is the API response
+type RepositoriesResponse map[string]RepositoryResponse
+
+// RepositoryResponse is the API response
+type RepositoryResponse struct {
+	Type string `json:"type"`
+}
+
+// VerifyRepositoryResponse is the API response
+type VerifyRepositoryResponse struct {
+	Nodes map[string]VerifyRepositoryNode `json:"nodes"`
+}
+
+// VerifyRepositoryNode is the API response
+type VerifyRepositoryNode struct {
+	Name string `json:"name"`
+}
+
+// ErrorResponse is the API response on error
+type ErrorResponse struct {
+	Error *ErrorCause `json:"error,omitempty"`
+}
+
+// ErrorCause is the API response on error
+type ErrorCause struct {
+	Type      string       `json:"type"`
+	Reason    string       `json:"reason"`
+	RootCause []ErrorCause `json:"root_cause,omitempty"`
+}
+
+// CheckRepositoryVerify wrap command line to check
+func CheckRepositoryVerify(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckRepositoryVerify(c.Context, c.String("repository"), thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckRepositoryVerify check that the repository is reachable from all nodes.
+// Set repositoryName to empty or _all to check all registered repositories.
+// The thresholds are applied on the number of repositories that failed verification
+func (h *CheckES) CheckRepositoryVerify(ctx context.Context, repositoryName string, thresholds *Thresholds) (*Monitoring, error) {
+
+	if repositoryName == "" {
+		repositoryName = "_all"
+	}
+	log.Debugf("RepositoryName: %s", repositoryName)
+	monitoringData := NewMonitoring()
+
+	// Query the registered repositories
+	res, err := h.client.API.Snapshot.GetRepository(
+		h.client.API.Snapshot.GetRepository.WithContext(ctx),
+		h.client.API.Snapshot.GetRepository.WithRepository(repositoryName),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get repository %s", repositoryName)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+			monitoringData.AddMessage("Repository %s not found", repositoryName)
+			return monitoringData, nil
+		}
+		return nil, errors.Errorf("Error when get repository %s: %s", repositoryName, res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get repository %s successfully:\n%s", repositoryName, string(b))
+	repositoriesResponse := RepositoriesResponse{}
+	err = json.Unmarshal(b, &repositoriesResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(repositoriesResponse) == 0 {
+		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
+		monitoringData.AddMessage("No repository registered")
+		monitoringData.AddPerfdata("nbRepositories", 0, "")
+		monitoringData.AddPerfdataWithThresholds("nbRepositoriesFailed", 0, "", thresholds)
+		return monitoringData, nil
+	}
+
+	repositories := make([]string, 0, len(repositoriesResponse))
+	for repository := range repositoriesResponse {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+
+	// Verify each repository
+	failedRepositories := make([]string, 0)
+	verifyMessages := make([]string, 0, len(repositories))
+	for _, repository := range repositories {
+		failure, nbNodes, err := h.verifyRepository(ctx, repository)
+		if err != nil {
+			return nil, err
+		}
+		if failure != "" {
+			failedRepositories = append(failedRepositories, repository)
+			verifyMessages = append(verifyMessages, failure)
+		} else {
+			verifyMessages = append(verifyMessages, fmt.Sprintf("Repository %s verified on %d nodes", repository, nbNodes))
+		}
+	}
+
+	monitoringData.SetStatus(thresholds.Status(float64(len(failedRepositories))))
+	if len(failedRepositories) > 0 {
+		monitoringData.AddMessage("Some repositories failed verification (%d/%d)", len(repositories)-len(failedRepositories), len(repositories))
+	} else {
+		monitoringData.AddMessage("All repositories are verified (%d/%d)", len(repositories), len(repositories))
+	}
+	for _, message := range verifyMessages {
+		monitoringData.AddMessage(message)
+	}
+
+	monitoringData.AddPerfdata("nbRepositories", len(repositories), "")
+	monitoringData.AddPerfdataWithThresholds("nbRepositoriesFailed", len(failedRepositories), "", thresholds)
+
+	return monitoringData, nil
+}
+
+// verifyRepository verify the repository on all nodes.
+// It return the failure message when verification failed, else the number of nodes where the repository is verified
+func (h *CheckES) verifyRepository(ctx context.Context, repository string) (string, int, error) {
+
+	res, err := h.client.API.Snapshot.VerifyRepository(
+		repository,
+		h.client.API.Snapshot.VerifyRepository.WithContext(ctx),
+	)
+	if err != nil {
+		return "", 0, errors.Wrapf(err, "Error when verify repository %s", repository)
+	}
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if res.IsError() {
+		log.Debugf("Verify repository %s failed:\n%s", repository, string(b))
+		errorResponse := &ErrorResponse{}
+		if err = json.Unmarshal(b, errorResponse); err != nil || errorResponse.Error == nil {
+			return fmt.Sprintf("Repository %s failed verification: %s", repository, res.Status()), 0, nil
+		}
+		return fmt.Sprintf("Repository %s failed verification: %s", repository, errorResponse.Error.Reason), 0, nil
+	}
+
+	log.Debugf("Verify repository %s successfully:\n%s", repository, string(b))
+	verifyRepositoryResponse := &VerifyRepositoryResponse{}
+	if err = json.Unmarshal(b, verifyRepositoryResponse); err != nil {
+		return "", 0, err
+	}
+
+	return "", len(verifyRepositoryResponse.Nodes), nil
+}
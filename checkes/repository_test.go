@@ -0,0 +1,47 @@
+package checkes
+
+import (
+	"context"
+	"strings"
+
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *CheckESTestSuite) TestCheckRepositoryVerify() {
+
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
+	// When repository exist
+	checkES := s.monitorES.(*CheckES)
+	checkES.client.API.Snapshot.CreateRepository(
+		"snapshot",
+		strings.NewReader(`
+			{
+				"type": "fs",
+  				"settings": {
+    				"location": "/tmp",
+    				"compress": true
+  				}
+			}
+		`),
+		checkES.client.API.Snapshot.CreateRepository.WithContext(context.Background()),
+	)
+	monitoringData, err := s.monitorES.CheckRepositoryVerify(context.Background(), "snapshot", thresholds)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When check all repositories
+	monitoringData, err = s.monitorES.CheckRepositoryVerify(context.Background(), "", thresholds)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When repository not exist
+	monitoringData, err = s.monitorES.CheckRepositoryVerify(context.Background(), "foo", thresholds)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
+}
@@ -0,0 +1,107 @@
+package checkes
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v7/estransport"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// retryOnStatus is the response status that are retried
+var retryOnStatus = map[int]bool{
+	429: true,
+	502: true,
+	503: true,
+	504: true,
+}
+
+// retryTransport retry the requests on Elasticsearch.
+// The connection errors are retried at least on each other node, and the 429, 502, 503 and 504 status are retried up to the retries parameter.
+// The client transport mark the node as dead on connection error, so the next attempt is sent to another node
+type retryTransport struct {
+	transport         estransport.Interface
+	connectionRetries int
+	statusRetries     int
+	backoff           func(attempt int) time.Duration
+}
+
+// newRetryTransport return the retry transport from the number of URLs and the number of retries
+func newRetryTransport(transport estransport.Interface, nbURLs int, retries int) *retryTransport {
+	connectionRetries := retries
+	if nbURLs-1 > connectionRetries {
+		connectionRetries = nbURLs - 1
+	}
+
+	return &retryTransport{
+		transport:         transport,
+		connectionRetries: connectionRetries,
+		statusRetries:     retries,
+		backoff:           retryBackoff,
+	}
+}
+
+// Perform send the request and retry it when needed
+func (t *retryTransport) Perform(req *http.Request) (*http.Response, error) {
+
+	var nbConnectionRetries, nbStatusRetries int
+	for attempt := 1; ; attempt++ {
+		res, err := t.transport.Perform(req)
+
+		switch {
+		case err != nil && nbConnectionRetries < t.connectionRetries && req.Context().Err() == nil:
+			nbConnectionRetries++
+			log.Debugf("Retry request %s %s after error (%d/%d): %s", req.Method, req.URL.Path, nbConnectionRetries, t.connectionRetries, err.Error())
+		case err == nil && retryOnStatus[res.StatusCode] && nbStatusRetries < t.statusRetries:
+			nbStatusRetries++
+			log.Debugf("Retry request %s %s after status %d (%d/%d)", req.Method, req.URL.Path, res.StatusCode, nbStatusRetries, t.statusRetries)
+			io.Copy(ioutil.Discard, res.Body)
+			res.Body.Close()
+		default:
+			return res, err
+		}
+
+		// Rewind the body
+		if req.Body != nil && req.Body != http.NoBody {
+			if req.GetBody == nil {
+				return nil, errors.Errorf("Can't retry request %s %s because body can't be read again", req.Method, req.URL.Path)
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, errors.Wrap(err, "Error when read again request body")
+			}
+			req.Body = body
+		}
+
+		timer := time.NewTimer(t.backoff(attempt))
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// DiscoverNodes discover the nodes with the client transport
+func (t *retryTransport) DiscoverNodes() error {
+	if discoverable, ok := t.transport.(estransport.Discoverable); ok {
+		return discoverable.DiscoverNodes()
+	}
+	return errors.New("Transport can't discover nodes")
+}
+
+// retryBackoff return the exponential time to wait before retry the request, from 100ms to 5s
+func retryBackoff(attempt int) time.Duration {
+	backoff := 100 * time.Millisecond
+	for i := 1; i < attempt && backoff < 5*time.Second; i++ {
+		backoff *= 2
+	}
+	if backoff > 5*time.Second {
+		return 5 * time.Second
+	}
+	return backoff
+}
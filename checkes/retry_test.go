@@ -0,0 +1,71 @@
+package checkes
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRetryTransport(t *testing.T) {
+
+	nbCalls := 0
+	nbUnavailable := 0
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		nbCalls++
+		if nbCalls <= nbUnavailable {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"status":"green"}`))
+	})
+	defer server.Close()
+
+	// When first node is unreachable and retries is disabled, the request is sent on the other node
+	for i := 0; i < 3; i++ {
+		_, err := NewCheckESFromConfig(context.Background(), &Config{URLs: []string{"http://127.0.0.1:1", server.URL}, Retries: 0})
+		assert.NoError(t, err)
+	}
+
+	// When all nodes are unreachable
+	_, err := NewCheckESFromConfig(context.Background(), &Config{URLs: []string{"http://127.0.0.1:1"}, Retries: 0})
+	assert.Error(t, err)
+
+	monitorES, err := NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, Retries: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := monitorES.(*CheckES).client
+
+	// When status is retried
+	nbCalls, nbUnavailable = 0, 1
+	res, err := client.Cluster.Health()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, 2, nbCalls)
+
+	// When status is retried up to the retries parameter
+	nbCalls, nbUnavailable = 0, 5
+	res, err = client.Cluster.Health()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, res.StatusCode)
+	assert.Equal(t, 2, nbCalls)
+}
+
+func TestNewRetryTransport(t *testing.T) {
+	transport := newRetryTransport(nil, 3, 0)
+	assert.Equal(t, 2, transport.connectionRetries)
+	assert.Equal(t, 0, transport.statusRetries)
+
+	transport = newRetryTransport(nil, 1, 3)
+	assert.Equal(t, 3, transport.connectionRetries)
+	assert.Equal(t, 3, transport.statusRetries)
+}
+
+func TestRetryBackoff(t *testing.T) {
+	assert.Equal(t, 100*time.Millisecond, retryBackoff(1))
+	assert.Equal(t, 400*time.Millisecond, retryBackoff(3))
+	assert.Equal(t, 5*time.Second, retryBackoff(20))
+}
@@ -0,0 +1,256 @@
+package checkes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// CatShardResponse is the API response
+type CatShardResponse struct {
+	Index            string `json:"index"`
+	Shard            string `json:"shard"`
+	PriRep           string `json:"prirep"`
+	State            string `json:"state"`
+	Node             string `json:"node,omitempty"`
+	UnassignedReason string `json:"unassigned.reason,omitempty"`
+}
+
+// AllocationExplainRequest is the API request
+type AllocationExplainRequest struct {
+	Index   string `json:"index"`
+	Shard   int    `json:"shard"`
+	Primary bool   `json:"primary"`
+}
+
+// AllocationExplainResponse is the API response
+type AllocationExplainResponse struct {
+	Index                   string                   `json:"index"`
+	Shard                   int                      `json:"shard"`
+	Primary                 bool                     `json:"primary"`
+	CurrentState            string                   `json:"current_state"`
+	UnassignedInfo          *UnassignedInfo          `json:"unassigned_info,omitempty"`
+	CanAllocate             string                   `json:"can_allocate,omitempty"`
+	AllocateExplanation     string                   `json:"allocate_explanation,omitempty"`
+	NodeAllocationDecisions []NodeAllocationDecision `json:"node_allocation_decisions,omitempty"`
+}
+
+// UnassignedInfo is the API response
+type UnassignedInfo struct {
+	Reason               string `json:"reason"`
+	At                   string `json:"at,omitempty"`
+	LastAllocationStatus string `json:"last_allocation_status,omitempty"`
+	Details              string `json:"details,omitempty"`
+}
+
+// NodeAllocationDecision is the API response
+type NodeAllocationDecision struct {
+	NodeName     string              `json:"node_name"`
+	NodeDecision string              `json:"node_decision"`
+	Deciders     []AllocationDecider `json:"deciders,omitempty"`
+}
+
+// AllocationDecider is the API response
+type AllocationDecider struct {
+	Decider     string `json:"decider"`
+	Decision    string `json:"decision"`
+	Explanation string `json:"explanation"`
+}
+
+// CheckUnassignedShards wrap command line to check
+func CheckUnassignedShards(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Int("max-explain") < 0 {
+		return errors.New("--max-explain parameter can't be negative")
+	}
+
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckUnassignedShards(c.Context, c.Int("max-explain"), thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckUnassignedShards check that there are no unassigned shards and explain why they are unassigned.
+// It only call the allocation explain API on the first maxExplain unassigned shards.
+// Unassigned primary shards are critical. Unassigned replica shards are warning, or the thresholds are applied on the number of unassigned shards when set
+func (h *CheckES) CheckUnassignedShards(ctx context.Context, maxExplain int, thresholds *Thresholds) (*Monitoring, error) {
+
+	log.Debugf("MaxExplain: %d", maxExplain)
+	log.Debugf("Thresholds: %+v", thresholds)
+	monitoringData := NewMonitoring()
+
+	catShardsResponse, err := h.getShards(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Search unassigned shards
+	unassignedShards := make([]CatShardResponse, 0)
+	nbPrimary := 0
+	nbInitializing := 0
+	for _, shard := range catShardsResponse {
+		switch shard.State {
+		case "UNASSIGNED":
+			unassignedShards = append(unassignedShards, shard)
+			if shard.PriRep == "p" {
+				nbPrimary++
+			}
+		case "INITIALIZING":
+			nbInitializing++
+		}
+	}
+
+	monitoringData.AddPerfdataWithThresholds("nbUnassignedShards", len(unassignedShards), "", thresholds)
+	monitoringData.AddPerfdata("nbUnassignedPrimaryShards", nbPrimary, "")
+	monitoringData.AddPerfdata("nbUnassignedReplicaShards", len(unassignedShards)-nbPrimary, "")
+	monitoringData.AddPerfdata("nbInitializingShards", nbInitializing, "")
+
+	switch {
+	case nbPrimary > 0:
+		monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
+	case thresholds.IsSet():
+		monitoringData.SetStatus(thresholds.Status(float64(len(unassignedShards))))
+	case len(unassignedShards) > 0:
+		monitoringData.SetStatus(nagiosPlugin.STATUS_WARNING)
+	default:
+		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
+	}
+
+	if len(unassignedShards) == 0 {
+		monitoringData.AddMessage("No unassigned shard (%d shards)", len(catShardsResponse))
+		return monitoringData, nil
+	}
+	monitoringData.AddMessage("There are %d unassigned shards (%d primaries, %d replicas)", len(unassignedShards), nbPrimary, len(unassignedShards)-nbPrimary)
+
+	// Explain why shards are unassigned
+	for i, shard := range unassignedShards {
+		shardType := "replica"
+		if shard.PriRep == "p" {
+			shardType = "primary"
+		}
+
+		if i >= maxExplain {
+			monitoringData.AddMessage("Shard %s/%s (%s): %s", shard.Index, shard.Shard, shardType, shard.UnassignedReason)
+			continue
+		}
+
+		allocationExplain, err := h.explainShardAllocation(ctx, shard)
+		if err != nil {
+			return nil, err
+		}
+		if allocationExplain == nil {
+			monitoringData.AddMessage("Shard %s/%s (%s): %s", shard.Index, shard.Shard, shardType, shard.UnassignedReason)
+			continue
+		}
+
+		reason := shard.UnassignedReason
+		if allocationExplain.UnassignedInfo != nil {
+			reason = allocationExplain.UnassignedInfo.Reason
+		}
+		monitoringData.AddMessage("Shard %s/%s (%s): %s - %s", shard.Index, shard.Shard, shardType, reason, allocationExplain.AllocateExplanation)
+		for _, nodeDecision := range allocationExplain.NodeAllocationDecisions {
+			for _, decider := range nodeDecision.Deciders {
+				if decider.Decision == "NO" {
+					monitoringData.AddMessage("\tNode %s (%s): %s", nodeDecision.NodeName, decider.Decider, decider.Explanation)
+				}
+			}
+		}
+	}
+
+	return monitoringData, nil
+}
+
+// explainShardAllocation call the allocation explain API for the unassigned shard.
+// It return nil if shard is not more unassigned
+func (h *CheckES) explainShardAllocation(ctx context.Context, shard CatShardResponse) (*AllocationExplainResponse, error) {
+
+	shardNumber, err := strconv.Atoi(shard.Shard)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when parse shard number %s", shard.Shard)
+	}
+	body, err := json.Marshal(&AllocationExplainRequest{
+		Index:   shard.Index,
+		Shard:   shardNumber,
+		Primary: shard.PriRep == "p",
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := h.client.API.Cluster.AllocationExplain(
+		h.client.API.Cluster.AllocationExplain.WithContext(ctx),
+		h.client.API.Cluster.AllocationExplain.WithBody(bytes.NewReader(body)),
+		h.client.API.Cluster.AllocationExplain.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when explain allocation of shard %s/%s", shard.Index, shard.Shard)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		// The shard has been assigned between the two calls
+		if res.StatusCode == 400 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("Error when explain allocation of shard %s/%s: %s", shard.Index, shard.Shard, res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get allocation explain of shard %s/%s successfully:\n%s", shard.Index, shard.Shard, string(b))
+	allocationExplainResponse := &AllocationExplainResponse{}
+	err = json.Unmarshal(b, allocationExplainResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return allocationExplainResponse, nil
+}
+
+// getShards return all shards of the cluster
+func (h *CheckES) getShards(ctx context.Context) ([]CatShardResponse, error) {
+
+	res, err := h.client.API.Cat.Shards(
+		h.client.API.Cat.Shards.WithContext(ctx),
+		h.client.API.Cat.Shards.WithFormat("json"),
+		h.client.API.Cat.Shards.WithH("index", "shard", "prirep", "state", "node", "unassigned.reason"),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when get shards")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get shards: %s", res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get shards successfully:\n%s", string(b))
+	catShardsResponse := make([]CatShardResponse, 0)
+	err = json.Unmarshal(b, &catShardsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	return catShardsResponse, nil
+}
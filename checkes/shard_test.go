@@ -0,0 +1,76 @@
+package checkes
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func (s *CheckESTestSuite) TestCheckUnassignedShards() {
+
+	checkES := s.monitorES.(*CheckES)
+
+	// Create index with replica that can't be allocated on single node cluster
+	checkES.client.API.Indices.Create(
+		"unassigned",
+		checkES.client.API.Indices.Create.WithContext(context.Background()),
+		checkES.client.API.Indices.Create.WithBody(strings.NewReader(`
+			{
+				"settings": {
+					"index": {
+						"number_of_replicas": 1
+					}
+				}
+			}
+		`)),
+	)
+
+	// When there are unassigned replica
+	monitoringData, err := s.monitorES.CheckUnassignedShards(context.Background(), 10, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When explain is disabled
+	monitoringData, err = s.monitorES.CheckUnassignedShards(context.Background(), 0, nil)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+}
+
+func TestCheckUnassignedShardsThresholds(t *testing.T) {
+
+	shards := `[{"index":"logs","shard":"0","prirep":"p","state":"STARTED","node":"node1"},{"index":"logs","shard":"0","prirep":"r","state":"UNASSIGNED"},{"index":"logs","shard":"1","prirep":"r","state":"INITIALIZING","node":"node2"}]`
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(shards))
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// When thresholds are not set, unassigned replica is warning
+	monitoringData, err := monitorES.CheckUnassignedShards(context.Background(), 0, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When thresholds are set
+	thresholds, err := NewThresholds("1", "5")
+	assert.NoError(t, err)
+	monitoringData, err = monitorES.CheckUnassignedShards(context.Background(), 0, thresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "nbUnassignedShards=1;1;5;;")
+	assert.Contains(t, monitoringData.ToString(), "nbInitializingShards=1;;;;")
+
+	// When primary shard is unassigned, it is always critical
+	shards = `[{"index":"logs","shard":"0","prirep":"p","state":"UNASSIGNED"}]`
+	monitoringData, err = monitorES.CheckUnassignedShards(context.Background(), 0, thresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+}
@@ -5,12 +5,17 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"math"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	"github.com/elastic/go-elasticsearch/v7/esapi"
 	"github.com/pkg/errors"
+	"github.com/robfig/cron/v3"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
 	"github.com/vtopc/epoch"
@@ -49,14 +54,93 @@ type SLMStatusResponse struct {
 type SLMResponse map[string]*SLM
 
 type SLM struct {
-	LastSuccess *SLMStatus `json:"last_success,omitempty"`
-	LastFailure *SLMStatus `json:"last_failure,omitempty"`
+	Version       int                `json:"version,omitempty"`
+	ModifiedDate  epoch.Milliseconds `json:"modified_date_millis"`
+	Policy        *SLMPolicy         `json:"policy,omitempty"`
+	NextExecution epoch.Milliseconds `json:"next_execution_millis"`
+	LastSuccess   *SLMStatus         `json:"last_success,omitempty"`
+	LastFailure   *SLMStatus         `json:"last_failure,omitempty"`
+	InProgress    *SLMInProgress     `json:"in_progress,omitempty"`
+}
+
+// SLMPolicy is the API response
+type SLMPolicy struct {
+	Name       string `json:"name"`
+	Schedule   string `json:"schedule"`
+	Repository string `json:"repository"`
+}
+
+// SLMInProgress is the API response
+type SLMInProgress struct {
+	Name      string             `json:"name"`
+	UUID      string             `json:"uuid,omitempty"`
+	State     string             `json:"state"`
+	StartTime epoch.Milliseconds `json:"start_time_millis"`
 }
 
 type SLMStatus struct {
-	SnapshotName string `json:"snapshot_name"`
-	Time epoch.Milliseconds `json:"time"`
-	Details string `json:"details,omitempty"`
+	SnapshotName string             `json:"snapshot_name"`
+	Time         epoch.Milliseconds `json:"time"`
+	Details      string             `json:"details,omitempty"`
+}
+
+// SnapshotCheckOptions is the settings to select the snapshots checked on repository
+type SnapshotCheckOptions struct {
+	// Last is the number of newest snapshots to check for failures
+	Last int
+	// Since is the maximum age of snapshots to check for failures
+	Since time.Duration
+	// IgnoreSuperseded ignore the failed snapshots followed by successful snapshot
+	IgnoreSuperseded bool
+	// PartialSeverity is the maximum status raised by partial snapshots
+	PartialSeverity int
+	// Filter select the snapshots to check by name
+	Filter *filter.Filter
+}
+
+// SLMPolicyCheckOptions is the settings to check the SLM policies execution
+type SLMPolicyCheckOptions struct {
+	// ScheduleTolerance is the factor applied on the time between two scheduled runs to get the time a run has to succeed. 0 disable the check
+	ScheduleTolerance float64
+	// MaxRunning is the maximum duration of in progress snapshot. 0 disable the check
+	MaxRunning time.Duration
+	// NeverSucceededSeverity is the maximum status raised by policies that never succeeded
+	NeverSucceededSeverity int
+	// Filter select the policies to check by name
+	Filter *filter.Filter
+}
+
+// slmScheduleParser parse the SLM schedules
+var slmScheduleParser = cron.NewParser(cron.Second | cron.Minute | cron.Hour | cron.Dom | cron.Month | cron.Dow | cron.Descriptor)
+
+// SLMStatsResponse is the API response
+type SLMStatsResponse struct {
+	RetentionRuns                 int64            `json:"retention_runs"`
+	RetentionFailed               int64            `json:"retention_failed"`
+	RetentionTimedOut             int64            `json:"retention_timed_out"`
+	TotalSnapshotsTaken           int64            `json:"total_snapshots_taken"`
+	TotalSnapshotsFailed          int64            `json:"total_snapshots_failed"`
+	TotalSnapshotsDeleted         int64            `json:"total_snapshots_deleted"`
+	TotalSnapshotDeletionFailures int64            `json:"total_snapshot_deletion_failures"`
+	PolicyStats                   []SLMPolicyStats `json:"policy_stats"`
+}
+
+// SLMPolicyStats is the API response
+type SLMPolicyStats struct {
+	Policy                   string `json:"policy"`
+	SnapshotsTaken           int64  `json:"snapshots_taken"`
+	SnapshotsFailed          int64  `json:"snapshots_failed"`
+	SnapshotsDeleted         int64  `json:"snapshots_deleted"`
+	SnapshotDeletionFailures int64  `json:"snapshot_deletion_failures"`
+}
+
+// SLMRetentionState is the SLM counters stored between two runs
+type SLMRetentionState struct {
+	Timestamp                time.Time        `json:"timestamp"`
+	RetentionFailed          int64            `json:"retention_failed"`
+	RetentionTimedOut        int64            `json:"retention_timed_out"`
+	SnapshotDeletionFailures int64            `json:"snapshot_deletion_failures"`
+	PoliciesSnapshotsFailed  map[string]int64 `json:"policies_snapshots_failed"`
 }
 
 // CheckSLMError wrap command line to check
@@ -71,13 +155,41 @@ func CheckSLMError(c *cli.Context) error {
 		return errors.New("You must set --repository parameter")
 	}
 
-	monitoringData, err := monitorES.CheckSLMError(c.String("repository"))
+	thresholds, err := manageThresholdParameters(c)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
 
-	return nil
+	ageThresholds, err := manageAgeThresholdParameters(c, "warning-max-age", "max-age")
+	if err != nil {
+		return err
+	}
+
+	if c.Int("last") < 0 {
+		return errors.New("--last parameter can't be negative")
+	}
+	if c.Duration("since") < 0 {
+		return errors.New("--since parameter can't be negative")
+	}
+	partialSeverity, err := ParseSeverity(c.String("partial-severity"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse --partial-severity parameter")
+	}
+	options := &SnapshotCheckOptions{
+		Last:             c.Int("last"),
+		Since:            c.Duration("since"),
+		IgnoreSuperseded: c.Bool("ignore-superseded"),
+		PartialSeverity:  partialSeverity,
+	}
+	if options.Filter, err = manageFilterParameters(c); err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckSLMError(c.Context, c.String("repository"), options, thresholds, ageThresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
 
 }
 
@@ -89,13 +201,60 @@ func CheckSLMPolicy(c *cli.Context) error {
 		return err
 	}
 
-	monitoringData, err := monitorES.CheckSLMPolicy(c.String("name"))
+	thresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+
+	if c.Float64("schedule-tolerance") != 0 && c.Float64("schedule-tolerance") < 1 {
+		return errors.New("--schedule-tolerance parameter must be 0 or at least 1")
+	}
+	if c.Duration("max-running") < 0 {
+		return errors.New("--max-running parameter can't be negative")
+	}
+	neverSucceededSeverity, err := ParseSeverity(c.String("never-succeeded-severity"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse --never-succeeded-severity parameter")
+	}
+	options := &SLMPolicyCheckOptions{
+		ScheduleTolerance:      c.Float64("schedule-tolerance"),
+		MaxRunning:             c.Duration("max-running"),
+		NeverSucceededSeverity: neverSucceededSeverity,
+	}
+	if options.Filter, err = manageFilterParameters(c); err != nil {
+		return err
+	}
+
+	monitoringData, err := monitorES.CheckSLMPolicy(c.Context, c.String("name"), options, thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
+
+}
+
+// CheckSLMRetention wrap command line to check
+func CheckSLMRetention(c *cli.Context) error {
+
+	monitorES, err := manageElasticsearchGlobalParameters(c)
+	if err != nil {
+		return err
+	}
+
+	retentionThresholds, err := manageThresholdParameters(c)
+	if err != nil {
+		return err
+	}
+	deletionThresholds, err := NewThresholds(c.String("warning-deletion-failures"), c.String("critical-deletion-failures"))
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
 
-	return nil
+	monitoringData, err := monitorES.CheckSLMRetention(c.Context, retentionThresholds, deletionThresholds, manageStateFileParameter(c, "slm-retention"))
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
 
 }
 
@@ -107,34 +266,41 @@ func CheckSLMStatus(c *cli.Context) error {
 		return err
 	}
 
-	monitoringData, err := monitorES.CheckSLMStatus()
+	monitoringData, err := monitorES.CheckSLMStatus(c.Context)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
-
-	return nil
+	return outputMonitoring(c, monitoringData)
 
 }
 
-// CheckSLMError check that there are no ILM policy failed on indice name
-func (h *CheckES) CheckSLMError(snapshotRepositoryName string) (*nagiosPlugin.Monitoring, error) {
+// CheckSLMError check that there are no snapshot failed on repository.
+// The options select the snapshots to check. When options is nil, all snapshots are checked and partial snapshots are critical.
+// The thresholds are applied on the number of failed snapshots, partial included. The status raised because of partial snapshots is capped by the partial severity.
+// The age thresholds are applied on the age in seconds of the newest successful snapshot
+func (h *CheckES) CheckSLMError(ctx context.Context, snapshotRepositoryName string, options *SnapshotCheckOptions, thresholds *Thresholds, ageThresholds *Thresholds) (*Monitoring, error) {
 
 	if snapshotRepositoryName == "" {
 		return nil, errors.New("SnapshotRepositoryName can't be empty")
 	}
+	if options == nil {
+		options = &SnapshotCheckOptions{
+			PartialSeverity: nagiosPlugin.STATUS_CRITICAL,
+		}
+	}
 	log.Debugf("snapshotRepositoryName: %s", snapshotRepositoryName)
-	monitoringData := nagiosPlugin.NewMonitoring()
+	log.Debugf("options: %+v", options)
+	monitoringData := NewMonitoring()
 
 	// Query if there are snapshot error
 	res, err := h.client.API.Snapshot.Get(
 		snapshotRepositoryName,
 		[]string{"_all"},
-		h.client.API.Snapshot.Get.WithContext(context.Background()),
+		h.client.API.Snapshot.Get.WithContext(ctx),
 		h.client.API.Snapshot.Get.WithPretty(),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrapf(err, "Error when get snapshots of repository %s", snapshotRepositoryName)
 	}
 	defer res.Body.Close()
 	if res.IsError() {
@@ -156,57 +322,279 @@ func (h *CheckES) CheckSLMError(snapshotRepositoryName string) (*nagiosPlugin.Mo
 		return nil, err
 	}
 
-	// Check if there are some snapshot failed
-	if (snapshotsResponse.Snaphots == nil) || (len(snapshotsResponse.Snaphots) == 0) {
-		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
-		monitoringData.AddMessage("No snapshot on repository %s", snapshotRepositoryName)
-		monitoringData.AddPerfdata("NbSnapshot", 0, "")
-		monitoringData.AddPerfdata("NbSnapshotFailed", 0, "")
-		return monitoringData, nil
+	// Filter snapshots by name
+	snapshots := make([]SnapshotResponse, 0, len(snapshotsResponse.Snaphots))
+	for _, snapshotResponse := range snapshotsResponse.Snaphots {
+		if !options.Filter.Match(snapshotResponse.Snapshot) {
+			log.Debugf("Snapshot %s is exclude", snapshotResponse.Snapshot)
+			continue
+		}
+		snapshots = append(snapshots, snapshotResponse)
 	}
+	sort.SliceStable(snapshots, func(i, j int) bool {
+		return snapshots[i].StartTime.Before(snapshots[j].StartTime)
+	})
 
+	// Check if there are some snapshot failed
 	nbSnapshot := 0
 	snapshotsFailed := make([]SnapshotResponse, 0)
-	monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
-	for _, snapshotResponse := range snapshotsResponse.Snaphots {
+	nbSnapshotPartial := 0
+	for _, snapshotResponse := range selectRecentSnapshots(snapshots, options) {
 		nbSnapshot++
-		if snapshotResponse.State != "SUCCESS" && snapshotResponse.State != "IN_PROGRESS" {
-			monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
-			snapshotsFailed = append(snapshotsFailed, snapshotResponse)
+		if snapshotResponse.State == "SUCCESS" || snapshotResponse.State == "IN_PROGRESS" {
+			continue
+		}
+		if options.IgnoreSuperseded && isSnapshotSuperseded(snapshots, snapshotResponse) {
+			log.Debugf("Snapshot %s is superseded by successful snapshot", snapshotResponse.Snapshot)
+			continue
+		}
+		if snapshotResponse.State == "PARTIAL" {
+			nbSnapshotPartial++
 		}
+		snapshotsFailed = append(snapshotsFailed, snapshotResponse)
 	}
-	if len(snapshotsFailed) > 0 {
-		monitoringData.AddMessage("Some snapshots failed (%d/%d)", nbSnapshot-len(snapshotsFailed), nbSnapshot)
-		for _, snapshotFailed := range snapshotsFailed {
+	// Partial snapshots are failed snapshots, but the status they raise is capped by the partial severity
+	nbSnapshotFailed := len(snapshotsFailed)
+	monitoringData.SetStatus(thresholds.Status(float64(nbSnapshotFailed - nbSnapshotPartial)))
+	partialStatus := thresholds.Status(float64(nbSnapshotFailed))
+	if partialStatus > options.PartialSeverity {
+		partialStatus = options.PartialSeverity
+	}
+	monitoringData.SetStatus(partialStatus)
 
-			var errorMsg strings.Builder
-			for _, failure := range snapshotFailed.Failures {
-				errorMsg.WriteString(fmt.Sprintf("\n\tIndice %s on node %s failed with status %s: %s", failure.Indice, failure.NodeID, failure.Status, failure.Reason))
-			}
+	monitoringData.AddPerfdata("NbSnapshot", nbSnapshot, "")
+	monitoringData.AddPerfdataWithThresholds("NbSnapshotFailed", nbSnapshotFailed, "", thresholds)
+	monitoringData.AddPerfdata("NbSnapshotPartial", nbSnapshotPartial, "")
+	freshnessStatus, freshnessMessage := checkSnapshotFreshness(snapshots, options.Since, ageThresholds, monitoringData)
+
+	// The summary report the stale newest successful snapshot, so it not contradict the status
+	var summary string
+	switch {
+	case len(snapshots) == 0:
+		summary = fmt.Sprintf("No snapshot on repository %s", snapshotRepositoryName)
+	case len(snapshotsFailed) > 0:
+		summary = fmt.Sprintf("Some snapshots failed (%d/%d)", nbSnapshot-len(snapshotsFailed), nbSnapshot)
+	case freshnessStatus != nagiosPlugin.STATUS_OK:
+		summary = fmt.Sprintf("No snapshot failed (%d/%d)", nbSnapshot, nbSnapshot)
+	default:
+		summary = fmt.Sprintf("All snapshots are ok (%d/%d)", nbSnapshot, nbSnapshot)
+	}
+	if freshnessStatus != nagiosPlugin.STATUS_OK {
+		summary = fmt.Sprintf("%s. %s", summary, freshnessMessage)
+	}
+	monitoringData.AddMessage(summary)
+	if freshnessStatus == nagiosPlugin.STATUS_OK && freshnessMessage != "" {
+		monitoringData.AddMessage(freshnessMessage)
+	}
 
-			monitoringData.AddMessage("Snapshot %s failed (%s - %s) with status %s: %s", snapshotFailed.Snapshot, snapshotFailed.StartTime, snapshotFailed.EndTime, snapshotFailed.State, errorMsg.String())
+	for _, snapshotFailed := range snapshotsFailed {
+
+		var errorMsg strings.Builder
+		for _, failure := range snapshotFailed.Failures {
+			errorMsg.WriteString(fmt.Sprintf("\n\tIndice %s on node %s failed with status %s: %s", failure.Indice, failure.NodeID, failure.Status, failure.Reason))
 		}
-	} else {
-		monitoringData.AddMessage("All snapshots are ok (%d/%d)", nbSnapshot, nbSnapshot)
+
+		monitoringData.AddMessage("Snapshot %s failed (%s - %s) with status %s: %s", snapshotFailed.Snapshot, snapshotFailed.StartTime, snapshotFailed.EndTime, snapshotFailed.State, errorMsg.String())
 	}
 
-	monitoringData.AddPerfdata("NbSnapshot", nbSnapshot, "")
-	monitoringData.AddPerfdata("NbSnapshotFailed", len(snapshotsFailed), "")
+	return monitoringData, nil
+}
+
+// selectRecentSnapshots return the snapshots started since options.Since and the options.Last newest ones.
+// The snapshots must be sorted by start time
+func selectRecentSnapshots(snapshots []SnapshotResponse, options *SnapshotCheckOptions) []SnapshotResponse {
+
+	if options.Since > 0 {
+		since := time.Now().Add(-options.Since)
+		idx := sort.Search(len(snapshots), func(i int) bool {
+			return !snapshots[i].StartTime.Before(since)
+		})
+		snapshots = snapshots[idx:]
+	}
+	if options.Last > 0 && len(snapshots) > options.Last {
+		snapshots = snapshots[len(snapshots)-options.Last:]
+	}
+
+	return snapshots
+}
+
+// isSnapshotSuperseded return true if there are successful snapshot started after the snapshot
+func isSnapshotSuperseded(snapshots []SnapshotResponse, snapshot SnapshotResponse) bool {
+	for _, s := range snapshots {
+		if s.State == "SUCCESS" && s.StartTime.After(snapshot.StartTime) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkSnapshotFreshness apply the age thresholds on the newest successful snapshot and add its age on perfdata.
+// When there are no successful snapshot, the age is the one of the oldest snapshot, or the window when there are no snapshot.
+// It return the freshness status and message, or OK and empty message when age thresholds is nil
+func checkSnapshotFreshness(snapshots []SnapshotResponse, window time.Duration, ageThresholds *Thresholds, monitoringData *Monitoring) (int, string) {
+
+	if ageThresholds == nil {
+		return nagiosPlugin.STATUS_OK, ""
+	}
+
+	var newestSuccess, oldest *SnapshotResponse
+	for i, snapshot := range snapshots {
+		if snapshot.State == "SUCCESS" && (newestSuccess == nil || snapshot.EndTime.After(newestSuccess.EndTime)) {
+			newestSuccess = &snapshots[i]
+		}
+		if oldest == nil || snapshot.StartTime.Before(oldest.StartTime) {
+			oldest = &snapshots[i]
+		}
+	}
+
+	var age time.Duration
+	var status int
+	var message string
+	switch {
+	case newestSuccess != nil:
+		age = time.Since(newestSuccess.EndTime)
+		status = ageThresholds.Status(age.Seconds())
+		if status != nagiosPlugin.STATUS_OK {
+			message = fmt.Sprintf("The newest successful snapshot %s is too old: %s", newestSuccess.Snapshot, age.Round(time.Second))
+		} else {
+			message = fmt.Sprintf("The newest successful snapshot %s is %s old", newestSuccess.Snapshot, age.Round(time.Second))
+		}
+	case oldest != nil || window > 0:
+		age = window
+		if oldest != nil {
+			age = time.Since(oldest.StartTime)
+		}
+		status = ageThresholds.Status(math.Inf(1))
+		message = fmt.Sprintf("There are no successful snapshot since %s", age.Round(time.Second))
+	default:
+		status = ageThresholds.Status(math.Inf(1))
+		message = "There are no successful snapshot"
+	}
+
+	monitoringData.SetStatus(status)
+	monitoringData.AddPerfdataWithThresholds("newestSuccessAge", int(age.Seconds()), "s", ageThresholds)
+
+	return status, message
+}
+
+// CheckSLMRetention check that SLM retention runs not failed and snapshots deletion not failed since the last run.
+// The retention thresholds are applied on the number of failed and timed out retention runs since the last run, and the deletion thresholds on the number of snapshot deletion failures since the last run.
+// The SLM counters are cumulative since the master node start, so the previous sample is stored on state file.
+func (h *CheckES) CheckSLMRetention(ctx context.Context, retentionThresholds *Thresholds, deletionThresholds *Thresholds, stateFile string) (*Monitoring, error) {
+
+	log.Debugf("StateFile: %s", stateFile)
+	monitoringData := NewMonitoring()
+
+	// The state file is locked until the new state is saved, so concurrent checks not lose samples
+	unlock := lockStateFile(stateFile)
+	defer unlock()
+	previousState := &SLMRetentionState{}
+	if err := loadState(stateFile, previousState); err != nil {
+		return nil, err
+	}
+
+	// Query the SLM stats
+	res, err := h.client.API.SlmGetStats(
+		h.client.API.SlmGetStats.WithContext(ctx),
+		h.client.API.SlmGetStats.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when get SLM stats")
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get SLM stats: %s", res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get SLM stats successfully:\n%s", string(b))
+	slmStatsResponse := &SLMStatsResponse{}
+	err = json.Unmarshal(b, slmStatsResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	currentState := &SLMRetentionState{
+		Timestamp:                time.Now(),
+		RetentionFailed:          slmStatsResponse.RetentionFailed,
+		RetentionTimedOut:        slmStatsResponse.RetentionTimedOut,
+		SnapshotDeletionFailures: slmStatsResponse.TotalSnapshotDeletionFailures,
+		PoliciesSnapshotsFailed:  make(map[string]int64, len(slmStatsResponse.PolicyStats)),
+	}
+	for _, policyStats := range slmStatsResponse.PolicyStats {
+		currentState.PoliciesSnapshotsFailed[policyStats.Policy] = policyStats.SnapshotsFailed
+	}
+	if err = saveState(stateFile, currentState); err != nil {
+		return nil, err
+	}
+
+	monitoringData.AddPerfdata("retentionRuns", int(slmStatsResponse.RetentionRuns), "c")
+	monitoringData.AddPerfdata("retentionFailed", int(slmStatsResponse.RetentionFailed), "c")
+	monitoringData.AddPerfdata("retentionTimedOut", int(slmStatsResponse.RetentionTimedOut), "c")
+	monitoringData.AddPerfdata("snapshotsDeleted", int(slmStatsResponse.TotalSnapshotsDeleted), "c")
+	monitoringData.AddPerfdata("snapshotDeletionFailures", int(slmStatsResponse.TotalSnapshotDeletionFailures), "c")
+	sort.Slice(slmStatsResponse.PolicyStats, func(i, j int) bool {
+		return slmStatsResponse.PolicyStats[i].Policy < slmStatsResponse.PolicyStats[j].Policy
+	})
+	for _, policyStats := range slmStatsResponse.PolicyStats {
+		monitoringData.AddPerfdata(fmt.Sprintf("%s_snapshotsFailed", policyStats.Policy), int(policyStats.SnapshotsFailed), "c")
+	}
+
+	// Counters are reset when master node change
+	if previousState.Timestamp.IsZero() || previousState.RetentionFailed > currentState.RetentionFailed || previousState.RetentionTimedOut > currentState.RetentionTimedOut || previousState.SnapshotDeletionFailures > currentState.SnapshotDeletionFailures {
+		log.Debugf("No previous sample of SLM stats")
+		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
+		monitoringData.AddMessage("No previous SLM stats, the counters are stored for the next run")
+		return monitoringData, nil
+	}
+
+	brokenMessages := make([]string, 0)
+	retentionFailed := (currentState.RetentionFailed - previousState.RetentionFailed) + (currentState.RetentionTimedOut - previousState.RetentionTimedOut)
+	status := retentionThresholds.Status(float64(retentionFailed))
+	monitoringData.SetStatus(status)
+	if status != nagiosPlugin.STATUS_OK {
+		brokenMessages = append(brokenMessages, fmt.Sprintf("%d retention runs failed and %d timed out since last run", currentState.RetentionFailed-previousState.RetentionFailed, currentState.RetentionTimedOut-previousState.RetentionTimedOut))
+	}
+	monitoringData.AddPerfdataWithThresholds("retentionFailedSinceLastRun", int(retentionFailed), "", retentionThresholds)
+
+	deletionFailures := currentState.SnapshotDeletionFailures - previousState.SnapshotDeletionFailures
+	status = deletionThresholds.Status(float64(deletionFailures))
+	monitoringData.SetStatus(status)
+	if status != nagiosPlugin.STATUS_OK {
+		brokenMessages = append(brokenMessages, fmt.Sprintf("%d snapshot deletions failed since last run", deletionFailures))
+	}
+	monitoringData.AddPerfdataWithThresholds("deletionFailuresSinceLastRun", int(deletionFailures), "", deletionThresholds)
+
+	if len(brokenMessages) > 0 {
+		monitoringData.AddMessage("SLM retention has some failures")
+		for _, brokenMessage := range brokenMessages {
+			monitoringData.AddMessage(brokenMessage)
+		}
+	} else {
+		monitoringData.AddMessage("SLM retention works fine")
+	}
+	for _, policyStats := range slmStatsResponse.PolicyStats {
+		if previousSnapshotsFailed, ok := previousState.PoliciesSnapshotsFailed[policyStats.Policy]; ok && policyStats.SnapshotsFailed > previousSnapshotsFailed {
+			monitoringData.AddMessage("Policy %s has %d snapshots failed since last run", policyStats.Policy, policyStats.SnapshotsFailed-previousSnapshotsFailed)
+		}
+	}
 
 	return monitoringData, nil
 }
 
 // CheckSLMStatus check that SLM service is running
-func (h *CheckES) CheckSLMStatus() (*nagiosPlugin.Monitoring, error) {
+func (h *CheckES) CheckSLMStatus(ctx context.Context) (*Monitoring, error) {
 
-	monitoringData := nagiosPlugin.NewMonitoring()
+	monitoringData := NewMonitoring()
 
 	res, err := h.client.API.SlmGetStatus(
-		h.client.API.SlmGetStatus.WithContext(context.Background()),
+		h.client.API.SlmGetStatus.WithContext(ctx),
 		h.client.API.SlmGetStatus.WithPretty(),
 	)
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error when get SLM status")
 	}
 	defer res.Body.Close()
 	if res.IsError() {
@@ -240,35 +628,40 @@ func (h *CheckES) CheckSLMStatus() (*nagiosPlugin.Monitoring, error) {
 	return monitoringData, nil
 }
 
-// CheckSLMPolicy check that there are no SLM policy failed
-func (h *CheckES) CheckSLMPolicy(policyName string) (*nagiosPlugin.Monitoring, error) {
+// CheckSLMPolicy check that there are no SLM policy failed, that policies not missed their schedule and that snapshots not running too long
+// The thresholds are applied on the number of failed policies, on the number of policies that missed schedule and on the number of policies running too long
+func (h *CheckES) CheckSLMPolicy(ctx context.Context, policyName string, options *SLMPolicyCheckOptions, thresholds *Thresholds) (*Monitoring, error) {
 
-	var(
+	var (
 		res *esapi.Response
 		err error
 	)
 
 	log.Debugf("policyName: %s", policyName)
-	monitoringData := nagiosPlugin.NewMonitoring()
-
+	if options == nil {
+		options = &SLMPolicyCheckOptions{
+			NeverSucceededSeverity: nagiosPlugin.STATUS_WARNING,
+		}
+	}
+	log.Debugf("Options: %+v", options)
+	monitoringData := NewMonitoring()
 
 	if policyName == "" {
 		res, err = h.client.API.SlmGetLifecycle(
-			h.client.API.SlmGetLifecycle.WithContext(context.Background()),
+			h.client.API.SlmGetLifecycle.WithContext(ctx),
 			h.client.API.SlmGetLifecycle.WithPretty(),
 		)
 	} else {
 		res, err = h.client.API.SlmGetLifecycle(
 			h.client.API.SlmGetLifecycle.WithPolicyID(policyName),
-			h.client.API.SlmGetLifecycle.WithContext(context.Background()),
+			h.client.API.SlmGetLifecycle.WithContext(ctx),
 			h.client.API.SlmGetLifecycle.WithPretty(),
 		)
 	}
 	if err != nil {
-		return nil, err
+		return nil, errors.Wrap(err, "Error when get SLM policies")
 	}
-	
-	
+
 	defer res.Body.Close()
 	if res.IsError() {
 		if res.StatusCode == 404 {
@@ -294,33 +687,189 @@ func (h *CheckES) CheckSLMPolicy(policyName string) (*nagiosPlugin.Monitoring, e
 		monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
 		monitoringData.AddMessage("No SLM policy %s", policyName)
 		monitoringData.AddPerfdata("NbSLMPolicyt", 0, "")
-		monitoringData.AddPerfdata("NbSLMPolicyFailed", 0, "")
+		monitoringData.AddPerfdataWithThresholds("NbSLMPolicyFailed", 0, "", thresholds)
 		return monitoringData, nil
 	}
 
-	nbSLMPolicy := 0
-	slmPoliciesFailed := make(map[string]*SLM)
-	monitoringData.SetStatus(nagiosPlugin.STATUS_OK)
-	for name, policy := range slmResponse {
-		nbSLMPolicy++
+	names := make([]string, 0, len(slmResponse))
+	for name := range slmResponse {
+		if !options.Filter.Match(name) {
+			log.Debugf("SLM policy %s is exclude", name)
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	now := time.Now()
+	var nbSLMPolicyFailed, nbSLMPolicyMissedSchedule, nbSLMPolicyUnknownSchedule, nbSLMPolicyRunningTooLong, nbSLMPolicyNeverSucceeded int
+	policiesNotOk := make(map[string]bool)
+	messages := make([]string, 0)
+	for _, name := range names {
+		policy := slmResponse[name]
+
 		if policy.LastFailure != nil {
 			if policy.LastSuccess == nil || policy.LastFailure.Time.After(policy.LastSuccess.Time.Time) {
-					monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
-					slmPoliciesFailed[name] = policy
+				nbSLMPolicyFailed++
+				policiesNotOk[name] = true
+				messages = append(messages, fmt.Sprintf("SLM policy %s failed on snapshot %s at %s: %s", name, policy.LastFailure.SnapshotName, policy.LastFailure.Time, policy.LastFailure.Details))
 			}
 		}
-	}
-	if len(slmPoliciesFailed) > 0 {
-		monitoringData.AddMessage("Some SLM policies failed (%d/%d)", nbSLMPolicy-len(slmPoliciesFailed), nbSLMPolicy)
-		for name, policyFailed := range slmPoliciesFailed {
-			monitoringData.AddMessage("SLM policy %s failed on snapshot %s at %s: %s", name, policyFailed.LastFailure.SnapshotName, policyFailed.LastFailure.Time, policyFailed.LastFailure.Details)
+
+		if policy.LastSuccess == nil {
+			nbSLMPolicyNeverSucceeded++
+			if options.NeverSucceededSeverity != nagiosPlugin.STATUS_OK {
+				policiesNotOk[name] = true
+			}
+			messages = append(messages, fmt.Sprintf("SLM policy %s never succeeded since its creation at %s", name, policy.ModifiedDate))
+		} else if options.ScheduleTolerance > 0 && policy.Policy != nil {
+			expectedRun, err := slmScheduleExpectedRun(policy, options.ScheduleTolerance, now)
+			if err != nil {
+				log.Debugf("Can't check schedule of SLM policy %s: %s", name, err.Error())
+				nbSLMPolicyUnknownSchedule++
+				policiesNotOk[name] = true
+				messages = append(messages, fmt.Sprintf("SLM policy %s has schedule %s that can't be checked: %s", name, policy.Policy.Schedule, err.Error()))
+			} else if policy.LastSuccess.Time.Before(expectedRun) {
+				nbSLMPolicyMissedSchedule++
+				policiesNotOk[name] = true
+				messages = append(messages, fmt.Sprintf("SLM policy %s missed its schedule %s: last success at %s is before the run scheduled at %s", name, policy.Policy.Schedule, policy.LastSuccess.Time, expectedRun))
+			}
 		}
+
+		if options.MaxRunning > 0 && policy.InProgress != nil && !policy.InProgress.StartTime.IsZero() {
+			if runningTime := now.Sub(policy.InProgress.StartTime.Time); runningTime > options.MaxRunning {
+				nbSLMPolicyRunningTooLong++
+				policiesNotOk[name] = true
+				messages = append(messages, fmt.Sprintf("SLM policy %s has snapshot %s running since %s", name, policy.InProgress.Name, runningTime.Truncate(time.Second)))
+			}
+		}
+	}
+
+	status := thresholds.Status(float64(nbSLMPolicyFailed))
+	status = int(math.Max(float64(status), float64(thresholds.Status(float64(nbSLMPolicyMissedSchedule)))))
+	status = int(math.Max(float64(status), float64(thresholds.Status(float64(nbSLMPolicyRunningTooLong)))))
+	if nbSLMPolicyNeverSucceeded > 0 {
+		status = int(math.Max(float64(status), float64(options.NeverSucceededSeverity)))
+	}
+	if nbSLMPolicyUnknownSchedule > 0 && status != nagiosPlugin.STATUS_CRITICAL {
+		status = nagiosPlugin.STATUS_UNKNOWN
+	}
+	monitoringData.SetStatus(status)
+
+	nbSLMPolicy := len(names)
+	if len(policiesNotOk) > 0 {
+		monitoringData.AddMessage("Some SLM policies are not ok (%d/%d)", nbSLMPolicy-len(policiesNotOk), nbSLMPolicy)
 	} else {
 		monitoringData.AddMessage("All SLM policies are ok (%d/%d)", nbSLMPolicy, nbSLMPolicy)
 	}
+	for _, message := range messages {
+		monitoringData.AddMessage(message)
+	}
 
 	monitoringData.AddPerfdata("NbSLMPolicy", nbSLMPolicy, "")
-	monitoringData.AddPerfdata("NbSLMPolicyFailed", len(slmPoliciesFailed), "")
+	monitoringData.AddPerfdataWithThresholds("NbSLMPolicyFailed", nbSLMPolicyFailed, "", thresholds)
+	monitoringData.AddPerfdataWithThresholds("NbSLMPolicyMissedSchedule", nbSLMPolicyMissedSchedule, "", thresholds)
+	monitoringData.AddPerfdataWithThresholds("NbSLMPolicyRunningTooLong", nbSLMPolicyRunningTooLong, "", thresholds)
+	monitoringData.AddPerfdata("NbSLMPolicyNeverSucceeded", nbSLMPolicyNeverSucceeded, "")
 
 	return monitoringData, nil
-}
\ No newline at end of file
+}
+
+// slmScheduleExpectedRun return the scheduled run that the last success of policy must follow.
+// The run scheduled before the next execution, or before now if not yet planned, is expected when the time between this run and the next one
+// multiplied by the tolerance factor minus one is elapsed. Else the run scheduled before it is expected
+func slmScheduleExpectedRun(policy *SLM, tolerance float64, now time.Time) (time.Time, error) {
+	cronSchedule, err := parseSLMSchedule(policy.Policy.Schedule)
+	if err != nil {
+		return time.Time{}, err
+	}
+	next := cronSchedule.Next(now)
+	if !policy.NextExecution.IsZero() {
+		next = policy.NextExecution.Time
+	}
+	if next.IsZero() {
+		return time.Time{}, errors.Errorf("Schedule %s has no next execution", policy.Policy.Schedule)
+	}
+
+	previous, err := slmSchedulePrevious(cronSchedule, next)
+	if err != nil {
+		return time.Time{}, errors.Wrapf(err, "Error when search previous execution of schedule %s", policy.Policy.Schedule)
+	}
+	if now.Before(previous.Add(time.Duration(float64(next.Sub(previous)) * (tolerance - 1)))) {
+		if previous, err = slmSchedulePrevious(cronSchedule, previous); err != nil {
+			return time.Time{}, errors.Wrapf(err, "Error when search previous execution of schedule %s", policy.Policy.Schedule)
+		}
+	}
+
+	return previous, nil
+}
+
+// slmSchedulePrevious return the last execution of schedule before the given time
+func slmSchedulePrevious(cronSchedule cron.Schedule, before time.Time) (time.Time, error) {
+	for window := time.Second; window <= 5*366*24*time.Hour; window *= 2 {
+		previous := cronSchedule.Next(before.Add(-window))
+		if previous.IsZero() || !previous.Before(before) {
+			continue
+		}
+		for {
+			next := cronSchedule.Next(previous)
+			if next.IsZero() || !next.Before(before) {
+				return previous, nil
+			}
+			previous = next
+		}
+	}
+
+	return time.Time{}, errors.Errorf("No execution before %s", before)
+}
+
+// parseSLMSchedule parse the SLM schedule, that use the Quartz cron syntax with seconds and optional year
+func parseSLMSchedule(schedule string) (cron.Schedule, error) {
+	fields := strings.Fields(schedule)
+	if len(fields) == 7 {
+		if fields[6] != "*" && fields[6] != "?" {
+			return nil, errors.Errorf("Year on schedule %s is not supported", schedule)
+		}
+		fields = fields[:6]
+	}
+	if len(fields) == 6 {
+		dayOfWeek, err := convertQuartzDayOfWeek(fields[5])
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when parse schedule %s", schedule)
+		}
+		fields[5] = dayOfWeek
+	}
+	cronSchedule, err := slmScheduleParser.Parse(strings.Join(fields, " "))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when parse schedule %s", schedule)
+	}
+
+	return cronSchedule, nil
+}
+
+// convertQuartzDayOfWeek convert the Quartz days of week, from 1 (SUN) to 7 (SAT), to cron days of week from 0 to 6.
+// The names like MON-FRI and the steps are kept
+func convertQuartzDayOfWeek(dayOfWeek string) (string, error) {
+	parts := strings.Split(dayOfWeek, ",")
+	for i, part := range parts {
+		days := part
+		step := ""
+		if index := strings.Index(part, "/"); index >= 0 {
+			days, step = part[:index], part[index:]
+		}
+		bounds := strings.Split(days, "-")
+		for j, bound := range bounds {
+			day, err := strconv.Atoi(bound)
+			if err != nil {
+				continue
+			}
+			if day < 1 || day > 7 {
+				return "", errors.Errorf("Day of week %d is not between 1 and 7", day)
+			}
+			bounds[j] = strconv.Itoa(day - 1)
+		}
+		parts[i] = strings.Join(bounds, "-") + step
+	}
+
+	return strings.Join(parts, ","), nil
+}
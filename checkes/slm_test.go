@@ -2,7 +2,12 @@ package checkes
 
 import (
 	"context"
+	"fmt"
+	"net/http"
+	"path/filepath"
 	"strings"
+	"testing"
+	"time"
 
 	nagiosPlugin "github.com/disaster37/go-nagios"
 	"github.com/stretchr/testify/assert"
@@ -10,6 +15,9 @@ import (
 
 func (s *CheckESTestSuite) TestCheckSLMError() {
 
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
 	// When reposiotry exist
 	checkES := s.monitorES.(*CheckES)
 	checkES.client.API.Snapshot.CreateRepository(
@@ -25,13 +33,13 @@ func (s *CheckESTestSuite) TestCheckSLMError() {
 		`),
 		checkES.client.API.Snapshot.CreateRepository.WithContext(context.Background()),
 	)
-	monitoringData, err := s.monitorES.CheckSLMError("snapshot")
+	monitoringData, err := s.monitorES.CheckSLMError(context.Background(), "snapshot", nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When repository not exist
-	monitoringData, err = s.monitorES.CheckSLMError("foo")
+	monitoringData, err = s.monitorES.CheckSLMError(context.Background(), "foo", nil, thresholds, nil)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
@@ -39,6 +47,9 @@ func (s *CheckESTestSuite) TestCheckSLMError() {
 
 func (s *CheckESTestSuite) TestCheckSLMPolicy() {
 
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
 	// When reposiotry exist
 	checkES := s.monitorES.(*CheckES)
 	checkES.client.API.SlmPutLifecycle(
@@ -64,18 +75,51 @@ func (s *CheckESTestSuite) TestCheckSLMPolicy() {
 		),
 		checkES.client.API.SlmPutLifecycle.WithContext(context.Background()),
 	)
-	monitoringData, err := s.monitorES.CheckSLMPolicy("")
+	// When policy never succeeded
+	monitoringData, err := s.monitorES.CheckSLMPolicy(context.Background(), "", nil, thresholds)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+
+	// When policy never succeeded is ignored
+	options := &SLMPolicyCheckOptions{
+		ScheduleTolerance:      1.5,
+		MaxRunning:             1 * time.Hour,
+		NeverSucceededSeverity: nagiosPlugin.STATUS_OK,
+	}
+	monitoringData, err = s.monitorES.CheckSLMPolicy(context.Background(), "", options, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When repository not exist
-	monitoringData, err = s.monitorES.CheckSLMPolicy("foo")
+	monitoringData, err = s.monitorES.CheckSLMPolicy(context.Background(), "foo", nil, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
 }
 
+func (s *CheckESTestSuite) TestCheckSLMRetention() {
+
+	retentionThresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+	deletionThresholds, err := NewThresholds("0", "")
+	assert.NoError(s.T(), err)
+	stateFile := filepath.Join(s.T().TempDir(), "slm-retention.json")
+
+	// When there are no previous stats
+	monitoringData, err := s.monitorES.CheckSLMRetention(context.Background(), retentionThresholds, deletionThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When there are previous stats
+	monitoringData, err = s.monitorES.CheckSLMRetention(context.Background(), retentionThresholds, deletionThresholds, stateFile)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+}
+
 func (s *CheckESTestSuite) TestCheckSLMStatus() {
 
 	checkES := s.monitorES.(*CheckES)
@@ -84,7 +128,7 @@ func (s *CheckESTestSuite) TestCheckSLMStatus() {
 	checkES.client.API.SlmStop(
 		checkES.client.API.SlmStop.WithContext(context.Background()),
 	)
-	monitoringData, err := s.monitorES.CheckSLMStatus()
+	monitoringData, err := s.monitorES.CheckSLMStatus(context.Background())
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
@@ -93,9 +137,228 @@ func (s *CheckESTestSuite) TestCheckSLMStatus() {
 	checkES.client.API.SlmStart(
 		checkES.client.API.SlmStart.WithContext(context.Background()),
 	)
-	monitoringData, err = s.monitorES.CheckSLMStatus()
+	monitoringData, err = s.monitorES.CheckSLMStatus(context.Background())
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 }
+
+func TestCheckSnapshotFreshness(t *testing.T) {
+
+	ageThresholds, err := NewThresholds("3600", "86400")
+	assert.NoError(t, err)
+	now := time.Now()
+	snapshots := []SnapshotResponse{
+		{Snapshot: "old", State: "SUCCESS", StartTime: now.Add(-49 * time.Hour), EndTime: now.Add(-48 * time.Hour)},
+		{Snapshot: "recent", State: "SUCCESS", StartTime: now.Add(-3 * time.Hour), EndTime: now.Add(-2 * time.Hour)},
+		{Snapshot: "failed", State: "FAILED", StartTime: now.Add(-30 * time.Hour), EndTime: now.Add(-1 * time.Minute)},
+	}
+
+	// When newest successful snapshot is older than warning
+	monitoringData := NewMonitoring()
+	status, message := checkSnapshotFreshness(snapshots, 0, ageThresholds, monitoringData)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, status)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	assert.Contains(t, message, "The newest successful snapshot recent is too old")
+
+	// When newest successful snapshot is older than critical
+	monitoringData = NewMonitoring()
+	status, _ = checkSnapshotFreshness(snapshots[:1], 0, ageThresholds, monitoringData)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, status)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+
+	// When there are no successful snapshot, the age is the one of the oldest snapshot
+	monitoringData = NewMonitoring()
+	status, message = checkSnapshotFreshness(snapshots[2:], 0, ageThresholds, monitoringData)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, status)
+	assert.Equal(t, "There are no successful snapshot since 30h0m0s", message)
+	assert.Contains(t, monitoringData.ToString(), "newestSuccessAge=108000s;3600;86400;;")
+
+	// When there are no snapshot, the age is the window
+	monitoringData = NewMonitoring()
+	status, _ = checkSnapshotFreshness(nil, 24*time.Hour, ageThresholds, monitoringData)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, status)
+	assert.Contains(t, monitoringData.ToString(), "newestSuccessAge=86400s;3600;86400;;")
+
+	// When freshness is not checked
+	monitoringData = NewMonitoring()
+	status, message = checkSnapshotFreshness(snapshots[2:], 0, nil, monitoringData)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, status)
+	assert.Empty(t, message)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.Empty(t, monitoringData.Perfdatas())
+}
+
+func TestCheckSLMErrorFreshnessSummary(t *testing.T) {
+
+	snapshots := ""
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"snapshots":[%s]}`, snapshots)
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(t, err)
+	ageThresholds, err := NewThresholds("3600", "86400")
+	assert.NoError(t, err)
+	options := &SnapshotCheckOptions{PartialSeverity: nagiosPlugin.STATUS_CRITICAL}
+
+	// When there are no successful snapshot
+	snapshots = fmt.Sprintf(`{"snapshot":"nightly-1","state":"FAILED","start_time":"%s","end_time":"%s"}`, time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano), time.Now().Add(-time.Hour).Format(time.RFC3339Nano))
+	monitoringData, err := monitorES.CheckSLMError(context.Background(), "snapshot", options, thresholds, ageThresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+	assert.Equal(t, "Some snapshots failed (0/1). There are no successful snapshot since 2h0m0s", monitoringData.Messages()[0])
+	assert.Contains(t, monitoringData.ToString(), "newestSuccessAge=7200s;3600;86400;;")
+
+	// When newest successful snapshot is too old, the summary not say that all is ok
+	snapshots = fmt.Sprintf(`{"snapshot":"nightly-1","state":"SUCCESS","start_time":"%s","end_time":"%s"}`, time.Now().Add(-3*time.Hour).Format(time.RFC3339Nano), time.Now().Add(-2*time.Hour).Format(time.RFC3339Nano))
+	monitoringData, err = monitorES.CheckSLMError(context.Background(), "snapshot", options, thresholds, ageThresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	assert.Equal(t, "No snapshot failed (1/1). The newest successful snapshot nightly-1 is too old: 2h0m0s", monitoringData.Messages()[0])
+}
+
+func TestCheckSLMErrorPartial(t *testing.T) {
+
+	snapshots := `{"snapshot":"nightly-1","state":"PARTIAL","start_time":"2022-10-16T01:00:00Z","end_time":"2022-10-16T02:00:00Z"},{"snapshot":"nightly-2","state":"SUCCESS","start_time":"2022-10-17T01:00:00Z","end_time":"2022-10-17T02:00:00Z"}`
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"snapshots":[%s]}`, snapshots)
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(t, err)
+
+	// When partial snapshots are critical, they are counted as failed snapshot
+	monitoringData, err := monitorES.CheckSLMError(context.Background(), "snapshot", &SnapshotCheckOptions{PartialSeverity: nagiosPlugin.STATUS_CRITICAL}, thresholds, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "NbSnapshotFailed=1;;0;;")
+	assert.Contains(t, monitoringData.ToString(), "NbSnapshotPartial=1;;;;")
+
+	// When partial snapshots are capped to warning, they are still counted as failed snapshot
+	monitoringData, err = monitorES.CheckSLMError(context.Background(), "snapshot", &SnapshotCheckOptions{PartialSeverity: nagiosPlugin.STATUS_WARNING}, thresholds, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "NbSnapshotFailed=1;;0;;")
+
+	// When partial snapshots are ignored
+	monitoringData, err = monitorES.CheckSLMError(context.Background(), "snapshot", &SnapshotCheckOptions{PartialSeverity: nagiosPlugin.STATUS_OK}, thresholds, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, monitoringData.Status())
+	assert.Contains(t, monitoringData.ToString(), "NbSnapshotFailed=1;;0;;")
+}
+
+func TestSelectRecentSnapshots(t *testing.T) {
+
+	now := time.Now()
+	snapshots := []SnapshotResponse{
+		{Snapshot: "s1", State: "FAILED", StartTime: now.Add(-72 * time.Hour)},
+		{Snapshot: "s2", State: "SUCCESS", StartTime: now.Add(-48 * time.Hour)},
+		{Snapshot: "s3", State: "PARTIAL", StartTime: now.Add(-24 * time.Hour)},
+		{Snapshot: "s4", State: "SUCCESS", StartTime: now.Add(-1 * time.Hour)},
+	}
+
+	assert.Len(t, selectRecentSnapshots(snapshots, &SnapshotCheckOptions{}), 4)
+	assert.Equal(t, []SnapshotResponse{snapshots[2], snapshots[3]}, selectRecentSnapshots(snapshots, &SnapshotCheckOptions{Last: 2}))
+	assert.Equal(t, []SnapshotResponse{snapshots[1], snapshots[2], snapshots[3]}, selectRecentSnapshots(snapshots, &SnapshotCheckOptions{Since: 50 * time.Hour}))
+	assert.Equal(t, []SnapshotResponse{snapshots[3]}, selectRecentSnapshots(snapshots, &SnapshotCheckOptions{Since: 50 * time.Hour, Last: 1}))
+
+	assert.True(t, isSnapshotSuperseded(snapshots, snapshots[0]))
+	assert.True(t, isSnapshotSuperseded(snapshots, snapshots[2]))
+	assert.False(t, isSnapshotSuperseded(snapshots[:3], snapshots[2]))
+}
+
+func TestParseSLMSchedule(t *testing.T) {
+
+	from := time.Date(2022, 1, 10, 12, 0, 0, 0, time.UTC)
+
+	// When daily schedule
+	cronSchedule, err := parseSLMSchedule("0 30 1 * * ?")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 11, 1, 30, 0, 0, time.UTC), cronSchedule.Next(from))
+
+	// When hourly schedule with year
+	cronSchedule, err = parseSLMSchedule("0 0 * * * ? *")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 10, 13, 0, 0, 0, time.UTC), cronSchedule.Next(from))
+
+	// When day of week use Quartz numbers, 1 is sunday
+	cronSchedule, err = parseSLMSchedule("0 0 1 ? * 1")
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 16, 1, 0, 0, 0, time.UTC), cronSchedule.Next(from))
+
+	// When schedule is not supported
+	_, err = parseSLMSchedule("0 0 1 L * ?")
+	assert.Error(t, err)
+	_, err = parseSLMSchedule("0 0 1 ? * 8")
+	assert.Error(t, err)
+}
+
+func TestConvertQuartzDayOfWeek(t *testing.T) {
+	dayOfWeek, err := convertQuartzDayOfWeek("2-6,1/2")
+	assert.NoError(t, err)
+	assert.Equal(t, "1-5,0/2", dayOfWeek)
+
+	dayOfWeek, err = convertQuartzDayOfWeek("MON-FRI")
+	assert.NoError(t, err)
+	assert.Equal(t, "MON-FRI", dayOfWeek)
+
+	dayOfWeek, err = convertQuartzDayOfWeek("?")
+	assert.NoError(t, err)
+	assert.Equal(t, "?", dayOfWeek)
+}
+
+func TestSLMScheduleExpectedRun(t *testing.T) {
+
+	// Monday 2022-01-10 12:00
+	now := time.Date(2022, 1, 10, 12, 0, 0, 0, time.UTC)
+	policy := &SLM{
+		Policy: &SLMPolicy{
+			Schedule: "0 30 1 * * ?",
+		},
+	}
+	policy.NextExecution.Time = time.Date(2022, 1, 11, 1, 30, 0, 0, time.UTC)
+
+	// When the run of today is expected
+	expectedRun, err := slmScheduleExpectedRun(policy, 1.2, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 10, 1, 30, 0, 0, time.UTC), expectedRun)
+
+	// When the run of today can be in progress
+	expectedRun, err = slmScheduleExpectedRun(policy, 1.5, now)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 9, 1, 30, 0, 0, time.UTC), expectedRun)
+
+	// When schedule is on working days, the run of friday is expected during the weekend
+	policy.Policy.Schedule = "0 0 1 ? * MON-FRI"
+	policy.NextExecution.Time = time.Date(2022, 1, 10, 1, 0, 0, 0, time.UTC)
+	expectedRun, err = slmScheduleExpectedRun(policy, 1.5, time.Date(2022, 1, 9, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 7, 1, 0, 0, 0, time.UTC), expectedRun)
+
+	// When schedule use Quartz days of week
+	policy.Policy.Schedule = "0 0 1 ? * 2-6"
+	expectedRun, err = slmScheduleExpectedRun(policy, 1.5, time.Date(2022, 1, 9, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 7, 1, 0, 0, 0, time.UTC), expectedRun)
+
+	// When next execution is not planned
+	policy.NextExecution.Time = time.Time{}
+	expectedRun, err = slmScheduleExpectedRun(policy, 1.5, time.Date(2022, 1, 9, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, time.Date(2022, 1, 7, 1, 0, 0, 0, time.UTC), expectedRun)
+
+	// When schedule is not supported
+	policy.Policy.Schedule = "0 0 1 L * ?"
+	_, err = slmScheduleExpectedRun(policy, 1.5, now)
+	assert.Error(t, err)
+}
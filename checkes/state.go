@@ -0,0 +1,104 @@
+package checkes
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// manageStateFileParameter return the state file to use to store sample between two runs.
+// When --state-file is not set, it return a file on temporary directory that depend of the check, the cluster URL or cloud ID and the keys.
+// The keys are the check parameters that select what is stored, so two checks with different parameters not share the same state file
+func manageStateFileParameter(c *cli.Context, checkName string, keys ...string) string {
+	if c.String("state-file") != "" {
+		return c.String("state-file")
+	}
+
+	cluster := strings.Join(manageURLParameter(c), ",")
+	if cluster == "" {
+		cluster = c.String("cloud-id")
+	}
+
+	if len(keys) > 0 {
+		cluster = fmt.Sprintf("%s|%s", cluster, strings.Join(keys, "|"))
+	}
+
+	return filepath.Join(os.TempDir(), fmt.Sprintf("check_elasticsearch_%s_%x.json", checkName, sha1.Sum([]byte(cluster))))
+}
+
+// stateFileLocks serialize the checks that use the same state file, when they run concurrently on daemon or batch mode
+var stateFileLocks sync.Map
+
+// lockStateFile lock the state file and return the function to unlock it.
+// The check must hold the lock from loadState to saveState, else concurrent checks overwrite the samples of each other
+func lockStateFile(stateFile string) func() {
+	lock, _ := stateFileLocks.LoadOrStore(stateFile, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	return lock.(*sync.Mutex).Unlock
+}
+
+// loadState read the state file on state. It keep the state untouched if the state file not exist yet
+func loadState(stateFile string, state interface{}) error {
+
+	if stateFile == "" {
+		return errors.New("StateFile can't be empty")
+	}
+	log.Debugf("Load state from %s", stateFile)
+
+	b, err := ioutil.ReadFile(stateFile)
+	if err != nil {
+		if os.IsNotExist(err) {
+			log.Debugf("State file %s not exist yet", stateFile)
+			return nil
+		}
+		return errors.Wrapf(err, "Error when read state file %s", stateFile)
+	}
+
+	if err = json.Unmarshal(b, state); err != nil {
+		return errors.Wrapf(err, "Error when decode state file %s", stateFile)
+	}
+
+	return nil
+}
+
+// saveState write the state on state file
+func saveState(stateFile string, state interface{}) error {
+
+	if stateFile == "" {
+		return errors.New("StateFile can't be empty")
+	}
+	log.Debugf("Save state on %s", stateFile)
+
+	b, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write on temporary file before to rename it to not corrupt the state if check is killed
+	tmpFile, err := ioutil.TempFile(filepath.Dir(stateFile), fmt.Sprintf("%s.*.tmp", filepath.Base(stateFile)))
+	if err != nil {
+		return errors.Wrapf(err, "Error when create temporary state file for %s", stateFile)
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err = tmpFile.Write(b); err != nil {
+		tmpFile.Close()
+		return errors.Wrapf(err, "Error when write state file %s", tmpFile.Name())
+	}
+	if err = tmpFile.Close(); err != nil {
+		return errors.Wrapf(err, "Error when write state file %s", tmpFile.Name())
+	}
+	if err = os.Rename(tmpFile.Name(), stateFile); err != nil {
+		return errors.Wrapf(err, "Error when write state file %s", stateFile)
+	}
+
+	return nil
+}
@@ -0,0 +1,88 @@
+package checkes
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestState(t *testing.T) {
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	state := map[string]int{}
+
+	// When state file not exist
+	err := loadState(stateFile, &state)
+	assert.NoError(t, err)
+	assert.Empty(t, state)
+
+	// When save and load state
+	err = saveState(stateFile, map[string]int{"foo": 1})
+	assert.NoError(t, err)
+	err = loadState(stateFile, &state)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]int{"foo": 1}, state)
+
+	// When state file is empty
+	assert.Error(t, loadState("", &state))
+	assert.Error(t, saveState("", state))
+}
+
+func TestSaveStateConcurrently(t *testing.T) {
+
+	stateFile := filepath.Join(t.TempDir(), "state.json")
+	errs := make(chan error, 20)
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- saveState(stateFile, map[string]int{"foo": i})
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+
+	state := map[string]int{}
+	assert.NoError(t, loadState(stateFile, &state))
+	assert.Contains(t, state, "foo")
+}
+
+func TestManageStateFileParameter(t *testing.T) {
+	stateFiles := make([]string, 0)
+	app := cli.NewApp()
+	app.Flags = []cli.Flag{
+		&cli.StringSliceFlag{
+			Name: "url",
+		},
+		&cli.StringFlag{
+			Name: "state-file",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		stateFiles = append(stateFiles, manageStateFileParameter(c, "thread-pool", "write"), manageStateFileParameter(c, "thread-pool", "search"), manageStateFileParameter(c, "thread-pool", "write"))
+		return nil
+	}
+
+	// When state file is computed, it depend of keys
+	err := app.Run([]string{"test", "--url", "http://node1:9200"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, stateFiles[0], stateFiles[1])
+	assert.Equal(t, stateFiles[0], stateFiles[2])
+
+	// When state file depend of the cluster
+	err = app.Run([]string{"test", "--url", "http://node2:9200"})
+	assert.NoError(t, err)
+	assert.NotEqual(t, stateFiles[0], stateFiles[3])
+
+	// When state file is set
+	err = app.Run([]string{"test", "--state-file", "/tmp/state.json"})
+	assert.NoError(t, err)
+	assert.Equal(t, "/tmp/state.json", stateFiles[6])
+}
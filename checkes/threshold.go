@@ -0,0 +1,164 @@
+package checkes
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	"github.com/urfave/cli/v2"
+)
+
+// Threshold is a Nagios range
+// See https://www.monitoring-plugins.org/doc/guidelines.html#THRESHOLDFORMAT
+type Threshold struct {
+	start  float64
+	end    float64
+	inside bool
+	raw    string
+}
+
+// Thresholds is the warning and critical thresholds of a value
+type Thresholds struct {
+	Warning  *Threshold
+	Critical *Threshold
+}
+
+// NewThreshold parse Nagios range like `10`, `10:`, `~:10`, `10:20` or `@10:20`.
+// It return nil threshold when range is empty
+func NewThreshold(rawRange string) (*Threshold, error) {
+
+	rawRange = strings.TrimSpace(rawRange)
+	if rawRange == "" {
+		return nil, nil
+	}
+
+	threshold := &Threshold{
+		start: 0,
+		end:   math.Inf(1),
+		raw:   rawRange,
+	}
+
+	value := rawRange
+	if strings.HasPrefix(value, "@") {
+		threshold.inside = true
+		value = value[1:]
+	}
+
+	var err error
+	if idx := strings.Index(value, ":"); idx >= 0 {
+		start := value[:idx]
+		end := value[idx+1:]
+		switch start {
+		case "~":
+			threshold.start = math.Inf(-1)
+		case "":
+			threshold.start = 0
+		default:
+			if threshold.start, err = strconv.ParseFloat(start, 64); err != nil {
+				return nil, errors.Wrapf(err, "Error when parse start of range %s", rawRange)
+			}
+		}
+		if end != "" {
+			if threshold.end, err = strconv.ParseFloat(end, 64); err != nil {
+				return nil, errors.Wrapf(err, "Error when parse end of range %s", rawRange)
+			}
+		}
+	} else {
+		if threshold.end, err = strconv.ParseFloat(value, 64); err != nil {
+			return nil, errors.Wrapf(err, "Error when parse range %s", rawRange)
+		}
+	}
+
+	if threshold.start > threshold.end {
+		return nil, errors.Errorf("Start of range %s can't be greater than end", rawRange)
+	}
+
+	return threshold, nil
+}
+
+// Match return true if the value raise an alert
+func (t *Threshold) Match(value float64) bool {
+	if t == nil {
+		return false
+	}
+
+	isInside := value >= t.start && value <= t.end
+	if t.inside {
+		return isInside
+	}
+	return !isInside
+}
+
+// String return the Nagios range
+func (t *Threshold) String() string {
+	if t == nil {
+		return ""
+	}
+	return t.raw
+}
+
+// NewThresholds parse the warning and critical Nagios ranges
+func NewThresholds(warning string, critical string) (*Thresholds, error) {
+
+	warningThreshold, err := NewThreshold(warning)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when parse warning threshold")
+	}
+	criticalThreshold, err := NewThreshold(critical)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when parse critical threshold")
+	}
+
+	return &Thresholds{
+		Warning:  warningThreshold,
+		Critical: criticalThreshold,
+	}, nil
+}
+
+// IsSet return true if warning or critical threshold is set
+func (t *Thresholds) IsSet() bool {
+	return t != nil && (t.Warning != nil || t.Critical != nil)
+}
+
+// Status return the Nagios status of the value
+func (t *Thresholds) Status(value float64) int {
+	if t == nil {
+		return nagiosPlugin.STATUS_OK
+	}
+
+	if t.Critical.Match(value) {
+		return nagiosPlugin.STATUS_CRITICAL
+	}
+	if t.Warning.Match(value) {
+		return nagiosPlugin.STATUS_WARNING
+	}
+	return nagiosPlugin.STATUS_OK
+}
+
+// manageAgeThresholdParameters read the warning and critical maximum durations and return them as thresholds in seconds.
+// It return nil thresholds when both are not set
+func manageAgeThresholdParameters(c *cli.Context, warningFlag string, criticalFlag string) (*Thresholds, error) {
+
+	if c.Duration(warningFlag) < 0 {
+		return nil, errors.Errorf("--%s parameter can't be negative", warningFlag)
+	}
+	if c.Duration(criticalFlag) < 0 {
+		return nil, errors.Errorf("--%s parameter can't be negative", criticalFlag)
+	}
+	if c.Duration(warningFlag) == 0 && c.Duration(criticalFlag) == 0 {
+		return nil, nil
+	}
+
+	warning := ""
+	if c.Duration(warningFlag) > 0 {
+		warning = strconv.FormatInt(int64(c.Duration(warningFlag).Seconds()), 10)
+	}
+	critical := ""
+	if c.Duration(criticalFlag) > 0 {
+		critical = strconv.FormatInt(int64(c.Duration(criticalFlag).Seconds()), 10)
+	}
+
+	return NewThresholds(warning, critical)
+}
@@ -0,0 +1,92 @@
+package checkes
+
+import (
+	"testing"
+
+	nagiosPlugin "github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewThreshold(t *testing.T) {
+
+	// When range is empty
+	threshold, err := NewThreshold("")
+	assert.NoError(t, err)
+	assert.Nil(t, threshold)
+	assert.False(t, threshold.Match(10))
+
+	// When range is 10
+	threshold, err = NewThreshold("10")
+	assert.NoError(t, err)
+	assert.False(t, threshold.Match(0))
+	assert.False(t, threshold.Match(10))
+	assert.True(t, threshold.Match(11))
+	assert.True(t, threshold.Match(-1))
+	assert.Equal(t, "10", threshold.String())
+
+	// When range is 10:
+	threshold, err = NewThreshold("10:")
+	assert.NoError(t, err)
+	assert.True(t, threshold.Match(9))
+	assert.False(t, threshold.Match(10))
+	assert.False(t, threshold.Match(1000))
+
+	// When range is ~:10
+	threshold, err = NewThreshold("~:10")
+	assert.NoError(t, err)
+	assert.False(t, threshold.Match(-1000))
+	assert.False(t, threshold.Match(10))
+	assert.True(t, threshold.Match(11))
+
+	// When range is 10:20
+	threshold, err = NewThreshold("10:20")
+	assert.NoError(t, err)
+	assert.True(t, threshold.Match(9))
+	assert.False(t, threshold.Match(15))
+	assert.True(t, threshold.Match(21))
+
+	// When range is @10:20
+	threshold, err = NewThreshold("@10:20")
+	assert.NoError(t, err)
+	assert.False(t, threshold.Match(9))
+	assert.True(t, threshold.Match(10))
+	assert.True(t, threshold.Match(20))
+	assert.False(t, threshold.Match(21))
+
+	// When range is wrong
+	_, err = NewThreshold("foo")
+	assert.Error(t, err)
+	_, err = NewThreshold("20:10")
+	assert.Error(t, err)
+}
+
+func TestThresholdsStatus(t *testing.T) {
+
+	// When thresholds is nil
+	var thresholds *Thresholds
+	assert.Equal(t, nagiosPlugin.STATUS_OK, thresholds.Status(100))
+	assert.False(t, thresholds.IsSet())
+
+	// When warning and critical are set
+	thresholds, err := NewThresholds("5", "10")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, thresholds.Status(5))
+	assert.Equal(t, nagiosPlugin.STATUS_WARNING, thresholds.Status(6))
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, thresholds.Status(11))
+
+	// When only critical is set
+	thresholds, err = NewThresholds("", "0")
+	assert.NoError(t, err)
+	assert.Equal(t, nagiosPlugin.STATUS_OK, thresholds.Status(0))
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, thresholds.Status(1))
+	assert.True(t, thresholds.IsSet())
+
+	// When thresholds are empty
+	thresholds, err = NewThresholds("", "")
+	assert.NoError(t, err)
+	assert.False(t, thresholds.IsSet())
+
+	// When range is wrong
+	_, err = NewThresholds("foo", "")
+	assert.Error(t, err)
+}
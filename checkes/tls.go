@@ -0,0 +1,209 @@
+package checkes
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// needTLSConfig return true if config set some TLS settings
+func (c *Config) needTLSConfig() bool {
+	return c.DisableTLSVerification || c.CACert != "" || c.ClientCert != "" || c.ClientKey != "" || c.TLSServerName != "" || c.CAFingerprint != ""
+}
+
+// newTransport return the HTTP transport with the TLS settings of config
+func newTransport(config *Config) (*http.Transport, error) {
+
+	tlsConfig, err := newTLSConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	// With CA fingerprint, the standard verification is disabled, so the server name of connection is given to the fingerprint verification
+	if config.CAFingerprint != "" {
+		fingerprint, err := parseFingerprint(config.CAFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		dialer := &net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}
+		transport.DialTLSContext = func(ctx context.Context, network string, addr string) (net.Conn, error) {
+			serverName := tlsConfig.ServerName
+			if serverName == "" {
+				host, _, err := net.SplitHostPort(addr)
+				if err != nil {
+					return nil, err
+				}
+				serverName = host
+			}
+			connTLSConfig := tlsConfig.Clone()
+			connTLSConfig.ServerName = serverName
+			connTLSConfig.VerifyConnection = func(state tls.ConnectionState) error {
+				return verifyFingerprint(state.PeerCertificates, fingerprint, serverName)
+			}
+			tlsDialer := &tls.Dialer{
+				NetDialer: dialer,
+				Config:    connTLSConfig,
+			}
+			return tlsDialer.DialContext(ctx, network, addr)
+		}
+	}
+
+	return transport, nil
+}
+
+// newTLSConfig return the TLS settings to connect on Elasticsearch
+func newTLSConfig(config *Config) (*tls.Config, error) {
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.TLSServerName,
+		InsecureSkipVerify: config.DisableTLSVerification,
+	}
+
+	if config.CACert != "" {
+		rootCAs, err := loadCACerts(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if config.ClientCert != "" || config.ClientKey != "" {
+		if config.ClientCert == "" || config.ClientKey == "" {
+			return nil, errors.New("You must set client certificate and client key at the same time")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.ClientCert, config.ClientKey)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when load client certificate %s", config.ClientCert)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	if config.CAFingerprint != "" {
+		if config.CACert != "" {
+			return nil, errors.New("You can't set CA certificate and CA fingerprint at the same time")
+		}
+		fingerprint, err := parseFingerprint(config.CAFingerprint)
+		if err != nil {
+			return nil, err
+		}
+		// The server certificate is verified against the certificate that match the fingerprint instead of the system CA.
+		// The server name is checked by the transport that know the host of each connection
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyConnection = func(state tls.ConnectionState) error {
+			return verifyFingerprint(state.PeerCertificates, fingerprint, config.TLSServerName)
+		}
+	}
+
+	return tlsConfig, nil
+}
+
+// loadCACerts read the PEM certificates from file or from all files on directory
+func loadCACerts(path string) (*x509.CertPool, error) {
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when read CA certificate %s", path)
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		entries, err := ioutil.ReadDir(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when read CA certificate directory %s", path)
+		}
+		files = make([]string, 0, len(entries))
+		for _, entry := range entries {
+			if !entry.IsDir() {
+				files = append(files, filepath.Join(path, entry.Name()))
+			}
+		}
+	}
+
+	pool := x509.NewCertPool()
+	nbFilesLoaded := 0
+	for _, file := range files {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Error when read CA certificate %s", file)
+		}
+		if pool.AppendCertsFromPEM(b) {
+			log.Debugf("Load CA certificate %s", file)
+			nbFilesLoaded++
+		} else if !info.IsDir() {
+			return nil, errors.Errorf("There are no PEM certificate on %s", file)
+		}
+	}
+	if nbFilesLoaded == 0 {
+		return nil, errors.Errorf("There are no PEM certificate on %s", path)
+	}
+
+	return pool, nil
+}
+
+// parseFingerprint decode the SHA-256 fingerprint as hex string, with or without colons
+func parseFingerprint(rawFingerprint string) ([]byte, error) {
+	fingerprint, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(rawFingerprint), ":", ""))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when decode CA fingerprint %s", rawFingerprint)
+	}
+	if len(fingerprint) != sha256.Size {
+		return nil, errors.Errorf("CA fingerprint %s is not a SHA-256 fingerprint", rawFingerprint)
+	}
+
+	return fingerprint, nil
+}
+
+// verifyFingerprint return error if none of certificates match the SHA-256 fingerprint,
+// or if the server certificate is not signed by the matched certificate or not valid for the server name
+func verifyFingerprint(certificates []*x509.Certificate, fingerprint []byte, serverName string) error {
+	if len(certificates) == 0 {
+		return errors.New("The server not send certificate")
+	}
+
+	var caCertificate *x509.Certificate
+	for _, certificate := range certificates {
+		digest := sha256.Sum256(certificate.Raw)
+		if bytes.Equal(digest[:], fingerprint) {
+			caCertificate = certificate
+			break
+		}
+	}
+	if caCertificate == nil {
+		return errors.Errorf("None of the server certificates match the CA fingerprint %x", fingerprint)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(caCertificate)
+	intermediates := x509.NewCertPool()
+	for _, certificate := range certificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	if _, err := certificates[0].Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		DNSName:       serverName,
+	}); err != nil {
+		return errors.Wrapf(err, "Error when verify server certificate with the CA fingerprint %x", fingerprint)
+	}
+
+	return nil
+}
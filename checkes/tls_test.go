@@ -0,0 +1,144 @@
+package checkes
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestTLSServer() *httptest.Server {
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Elastic-Product", "Elasticsearch")
+		w.Write([]byte(`{"cluster_name":"test","version":{"number":"7.17.0"}}`))
+	}))
+}
+
+func TestParseFingerprint(t *testing.T) {
+	fingerprint, err := parseFingerprint("AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89")
+	assert.NoError(t, err)
+	assert.Len(t, fingerprint, sha256.Size)
+
+	_, err = parseFingerprint("abcdef")
+	assert.Error(t, err)
+	_, err = parseFingerprint("xyz")
+	assert.Error(t, err)
+}
+
+func TestNewCheckESWithTLS(t *testing.T) {
+	server := newTestTLSServer()
+	defer server.Close()
+
+	// Without CA, the certificate is not trusted
+	_, err := NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}})
+	assert.Error(t, err)
+
+	// With CA file and CA directory
+	dir := t.TempDir()
+	caFile := filepath.Join(dir, "ca.pem")
+	err = ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(filepath.Join(dir, "README"), []byte("not a certificate"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	monitorES, err := NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CACert: caFile})
+	assert.NoError(t, err)
+	assert.Equal(t, "test", monitorES.ClusterName())
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CACert: dir})
+	assert.NoError(t, err)
+
+	// With fingerprint
+	digest := sha256.Sum256(server.Certificate().Raw)
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CAFingerprint: hex.EncodeToString(digest[:])})
+	assert.NoError(t, err)
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CAFingerprint: hex.EncodeToString(make([]byte, sha256.Size))})
+	assert.Error(t, err)
+
+	// With fingerprint, the server name is checked
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CAFingerprint: hex.EncodeToString(digest[:]), TLSServerName: "example.com"})
+	assert.NoError(t, err)
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CAFingerprint: hex.EncodeToString(digest[:]), TLSServerName: "foo.com"})
+	assert.Error(t, err)
+
+	// With fingerprint and CA file
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CAFingerprint: hex.EncodeToString(digest[:]), CACert: caFile})
+	assert.Error(t, err)
+
+	// Client certificate without key
+	_, err = NewCheckESFromConfig(context.Background(), &Config{URLs: []string{server.URL}, CACert: caFile, ClientCert: caFile})
+	assert.Error(t, err)
+}
+
+// newTestCertificate return a certificate signed by parent, or self-signed when parent is nil
+func newTestCertificate(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	if !isCA {
+		template.DNSNames = []string{name}
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	b, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certificate, err := x509.ParseCertificate(b)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return certificate, key
+}
+
+func TestVerifyFingerprint(t *testing.T) {
+	ca, caKey := newTestCertificate(t, "ca", true, nil, nil)
+	otherCA, otherCAKey := newTestCertificate(t, "other-ca", true, nil, nil)
+	leaf, _ := newTestCertificate(t, "es.company.com", false, ca, caKey)
+	otherLeaf, _ := newTestCertificate(t, "es.company.com", false, otherCA, otherCAKey)
+	digest := sha256.Sum256(ca.Raw)
+
+	// When leaf is signed by pinned CA
+	assert.NoError(t, verifyFingerprint([]*x509.Certificate{leaf, ca}, digest[:], "es.company.com"))
+
+	// When server name not match
+	assert.Error(t, verifyFingerprint([]*x509.Certificate{leaf, ca}, digest[:], "foo.company.com"))
+
+	// When chain contain pinned CA but leaf is not signed by it
+	assert.Error(t, verifyFingerprint([]*x509.Certificate{otherLeaf, ca}, digest[:], "es.company.com"))
+
+	// When chain not contain pinned CA
+	assert.Error(t, verifyFingerprint([]*x509.Certificate{otherLeaf, otherCA}, digest[:], "es.company.com"))
+
+	// When there are no certificate
+	assert.Error(t, verifyFingerprint(nil, digest[:], "es.company.com"))
+}
@@ -1,28 +1,104 @@
 package checkes
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
+	"math"
+	"time"
 
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
+	"github.com/vtopc/epoch"
 )
 
 // TransformStatData is the transform stats data response
 type TransformStatData struct {
-	ID     string `json:"id"`
-	State  string `json:"state"`
-	Reason string `json:"reason,omitempty"`
+	ID            string                 `json:"id"`
+	State         string                 `json:"state"`
+	Reason        string                 `json:"reason,omitempty"`
+	Health        *TransformHealth       `json:"health,omitempty"`
+	Checkpointing TransformCheckpointing `json:"checkpointing"`
+	Stats         TransformIndexerStats  `json:"stats"`
+}
+
+// TransformHealth is the transform stats data response
+type TransformHealth struct {
+	Status string                 `json:"status"`
+	Issues []TransformHealthIssue `json:"issues,omitempty"`
+}
+
+// TransformHealthIssue is the transform stats data response
+type TransformHealthIssue struct {
+	Issue   string `json:"issue"`
+	Details string `json:"details,omitempty"`
+	Count   int    `json:"count,omitempty"`
+}
+
+// TransformCheckpointing is the transform stats data response
+type TransformCheckpointing struct {
+	OperationsBehind int64               `json:"operations_behind,omitempty"`
+	Last             TransformCheckpoint `json:"last"`
+}
+
+// TransformCheckpoint is the transform stats data response
+type TransformCheckpoint struct {
+	Checkpoint int64              `json:"checkpoint"`
+	Timestamp  epoch.Milliseconds `json:"timestamp_millis"`
+}
+
+// TransformIndexerStats is the transform stats data response
+type TransformIndexerStats struct {
+	SearchFailures int64 `json:"search_failures"`
+	IndexFailures  int64 `json:"index_failures"`
 }
 
 // TransformStatsData is the transform stats data response
 type TransformStatsData struct {
+	Count          int                 `json:"count"`
 	TransformStats []TransformStatData `json:"transforms"`
 }
 
-// CheckILMError Wrap cli argument and call check
+// TransformsData is the transform data response
+type TransformsData struct {
+	Count      int             `json:"count"`
+	Transforms []TransformData `json:"transforms"`
+}
+
+// transformPageSize is the number of transforms read by request
+const transformPageSize = 1000
+
+// TransformData is the transform data response
+type TransformData struct {
+	ID   string                 `json:"id"`
+	Sync map[string]interface{} `json:"sync,omitempty"`
+}
+
+// TransformCheckOptions is the settings to check the transforms health, lag and failures
+type TransformCheckOptions struct {
+	// MaxOperationsBehind is the maximum number of operations behind of continuous transform. 0 disable the check
+	MaxOperationsBehind int64
+	// MaxCheckpointAge is the maximum age of last checkpoint of continuous transform with operations behind. 0 disable the check
+	MaxCheckpointAge time.Duration
+	// StoppedSeverity is the maximum status raised by stopped continuous transforms
+	StoppedSeverity int
+	// FailuresThresholds is the thresholds on the number of search and index failures since last run. nil disable the check
+	FailuresThresholds *Thresholds
+	// StateFile is the file where to store the failures counters between two runs
+	StateFile string
+}
+
+// TransformFailuresState is the transform failures counters stored between two runs
+type TransformFailuresState struct {
+	Timestamp  time.Time                        `json:"timestamp"`
+	Transforms map[string]TransformIndexerStats `json:"transforms"`
+}
+
+// CheckTransformError wrap command line to check
 func CheckTransformError(c *cli.Context) error {
 
 	monitorES, err := manageElasticsearchGlobalParameters(c)
@@ -30,55 +106,76 @@ func CheckTransformError(c *cli.Context) error {
 		return err
 	}
 
-	monitoringData, err := monitorES.CheckTransformError(c.String("name"), c.StringSlice("exclude"))
+	thresholds, err := manageThresholdParameters(c)
 	if err != nil {
 		return err
 	}
-	monitoringData.ToSdtOut()
 
-	return nil
+	if c.Int64("max-operations-behind") < 0 {
+		return errors.New("--max-operations-behind parameter can't be negative")
+	}
+	if c.Duration("max-checkpoint-age") < 0 {
+		return errors.New("--max-checkpoint-age parameter can't be negative")
+	}
+	stoppedSeverity, err := ParseSeverity(c.String("stopped-severity"))
+	if err != nil {
+		return errors.Wrap(err, "Error when parse --stopped-severity parameter")
+	}
+	transformFilter, err := manageFilterParameters(c)
+	if err != nil {
+		return err
+	}
+
+	options := &TransformCheckOptions{
+		MaxOperationsBehind: c.Int64("max-operations-behind"),
+		MaxCheckpointAge:    c.Duration("max-checkpoint-age"),
+		StoppedSeverity:     stoppedSeverity,
+	}
+	if c.String("warning-failures") != "" || c.String("critical-failures") != "" {
+		options.FailuresThresholds, err = NewThresholds(c.String("warning-failures"), c.String("critical-failures"))
+		if err != nil {
+			return errors.Wrap(err, "Error when parse failures thresholds")
+		}
+		options.StateFile = manageStateFileParameter(c, "transform", c.String("name"), transformFilter.String())
+	}
+
+	monitoringData, err := monitorES.CheckTransformError(c.Context, c.String("name"), transformFilter, options, thresholds)
+	if err != nil {
+		return err
+	}
+	return outputMonitoring(c, monitoringData)
 
 }
 
-// CheckTransformError check that there are no transform failed
-func (h *CheckES) CheckTransformError(transformName string, excludeTransforms []string) (*nagiosPlugin.Monitoring, error) {
+// CheckTransformError check that there are no transform failed or unhealthy.
+// It also check the lag of continuous transforms and the failures since last run when options are set.
+// The thresholds are applied on the number of failed transforms and on the number of continuous transforms behind
+func (h *CheckES) CheckTransformError(ctx context.Context, transformName string, transformFilter *filter.Filter, options *TransformCheckOptions, thresholds *Thresholds) (*Monitoring, error) {
 
 	if transformName == "" {
 		transformName = "_all"
 	}
 	log.Debugf("TransformName: %s", transformName)
-	log.Debugf("ExcludeTransform: %+v", excludeTransforms)
-	monitoringData := nagiosPlugin.NewMonitoring()
-
-	// Query if there are Transform error
-	res, err := h.client.API.TransformGetTransformStats(
-		transformName,
-		h.client.API.TransformGetTransformStats.WithSize(1000),
-		h.client.API.TransformGetTransformStats.WithPretty(),
-	)
-	if err != nil {
-		return nil, err
+	log.Debugf("TransformFilter: %s", transformFilter)
+	if options == nil {
+		options = &TransformCheckOptions{}
 	}
-	defer res.Body.Close()
-	if res.IsError() {
-		if res.StatusCode == 404 {
-			monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
-			monitoringData.AddMessage("Transform %s not found", transformName)
-			return monitoringData, nil
-		}
-		return nil, errors.Errorf("Error when get Transform stats %s: %s", transformName, res.String())
+	log.Debugf("Options: %+v", options)
+	if options.FailuresThresholds != nil && options.StateFile == "" {
+		return nil, errors.New("StateFile can't be empty when check failures")
 	}
-	b, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return nil, err
-	}
-	log.Debugf("Get Transform %s successfully:\n%s", transformName, string(b))
+	monitoringData := NewMonitoring()
 
-	transformStats := &TransformStatsData{}
-	err = json.Unmarshal(b, transformStats)
+	// Query if there are Transform error
+	transformStats, err := h.getTransformStats(ctx, transformName)
 	if err != nil {
 		return nil, err
 	}
+	if transformStats == nil {
+		monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+		monitoringData.AddMessage("Transform %s not found", transformName)
+		return monitoringData, nil
+	}
 
 	// Handle not found transform when id is provided
 	if len(transformStats.TransformStats) == 0 && transformName != "_all" && transformName != "*" {
@@ -87,48 +184,268 @@ func (h *CheckES) CheckTransformError(transformName string, excludeTransforms []
 		return monitoringData, nil
 	}
 
+	// The lag and the stopped state are only checked on continuous transforms
+	continuousTransforms := make(map[string]bool)
+	if options.MaxOperationsBehind > 0 || options.MaxCheckpointAge > 0 || options.StoppedSeverity != nagiosPlugin.STATUS_OK {
+		continuousTransforms, err = h.getContinuousTransforms(ctx, transformName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	previousState := &TransformFailuresState{}
+	currentState := &TransformFailuresState{
+		Timestamp:  time.Now(),
+		Transforms: make(map[string]TransformIndexerStats),
+	}
+	if options.FailuresThresholds != nil {
+		// The state file is locked until the new state is saved, so concurrent checks not lose samples
+		unlock := lockStateFile(options.StateFile)
+		defer unlock()
+		if err = loadState(options.StateFile, previousState); err != nil {
+			return nil, err
+		}
+	}
+
 	// Loop over index and exclude transform if needed
-	var isExclude bool
 	var nbTransformStarted int
 	var nbTranformFailed int
 	var nbTransformStopped int
+	var nbTransformDegraded int
+	var nbTransformBehind int
+	var nbTransformStoppedContinuous int
+	var nbTransform int
+	var nbFailures int64
+	transformsNotOk := make(map[string]bool)
+	messages := make([]string, 0)
+	now := time.Now()
 	for _, transformStat := range transformStats.TransformStats {
-		isExclude = false
-		for _, excludeTransform := range excludeTransforms {
-			if transformStat.ID == excludeTransform {
-				isExclude = true
-				log.Debugf("Transform %s is exclude", transformStat.ID)
-				break
+		if !transformFilter.Match(transformStat.ID) {
+			log.Debugf("Transform %s is exclude", transformStat.ID)
+			continue
+		}
+		nbTransform++
+
+		currentState.Transforms[transformStat.ID] = transformStat.Stats
+		if previousStats, ok := previousState.Transforms[transformStat.ID]; ok && transformStat.Stats.SearchFailures >= previousStats.SearchFailures && transformStat.Stats.IndexFailures >= previousStats.IndexFailures {
+			searchFailures := transformStat.Stats.SearchFailures - previousStats.SearchFailures
+			indexFailures := transformStat.Stats.IndexFailures - previousStats.IndexFailures
+			if searchFailures > 0 || indexFailures > 0 {
+				nbFailures += searchFailures + indexFailures
+				transformsNotOk[transformStat.ID] = true
+				messages = append(messages, fmt.Sprintf("Transform %s has %d search failures and %d index failures since last run", transformStat.ID, searchFailures, indexFailures))
 			}
 		}
-		if !isExclude {
-			if transformStat.State == "indexing" || transformStat.State == "started" {
-				nbTransformStarted++
-				continue
-			} else if transformStat.State == "stopped" || transformStat.State == "stopping" {
-				nbTransformStopped++
-				continue
-			} else {
+
+		if transformStat.State == "indexing" || transformStat.State == "started" {
+			nbTransformStarted++
+		} else if transformStat.State == "stopped" || transformStat.State == "stopping" {
+			nbTransformStopped++
+			if continuousTransforms[transformStat.ID] && options.StoppedSeverity != nagiosPlugin.STATUS_OK {
+				nbTransformStoppedContinuous++
+				transformsNotOk[transformStat.ID] = true
+				messages = append(messages, fmt.Sprintf("Continuous transform %s is %s", transformStat.ID, transformStat.State))
+			}
+			continue
+		} else {
+			nbTranformFailed++
+			transformsNotOk[transformStat.ID] = true
+			messages = append(messages, fmt.Sprintf("Transform %s %s: %s", transformStat.ID, transformStat.State, transformStat.Reason))
+			continue
+		}
+
+		if transformStat.Health != nil && transformStat.Health.Status != "" && transformStat.Health.Status != "green" {
+			if transformStat.Health.Status == "red" {
 				nbTranformFailed++
-				monitoringData.SetStatus(nagiosPlugin.STATUS_CRITICAL)
-				monitoringData.AddMessage("Transform %s %s: %s", transformStat.ID, transformStat.State, transformStat.Reason)
-				continue
+			} else {
+				nbTransformDegraded++
+			}
+			transformsNotOk[transformStat.ID] = true
+			messages = append(messages, fmt.Sprintf("Transform %s health is %s", transformStat.ID, transformStat.Health.Status))
+			for _, issue := range transformStat.Health.Issues {
+				messages = append(messages, fmt.Sprintf("\t%s (%d): %s", issue.Issue, issue.Count, issue.Details))
 			}
 		}
 
+		if continuousTransforms[transformStat.ID] {
+			operationsBehind := transformStat.Checkpointing.OperationsBehind
+			if options.MaxOperationsBehind > 0 && operationsBehind > options.MaxOperationsBehind {
+				nbTransformBehind++
+				transformsNotOk[transformStat.ID] = true
+				messages = append(messages, fmt.Sprintf("Transform %s is behind by %d operations", transformStat.ID, operationsBehind))
+			} else if options.MaxCheckpointAge > 0 && operationsBehind > 0 && !transformStat.Checkpointing.Last.Timestamp.IsZero() {
+				if checkpointAge := now.Sub(transformStat.Checkpointing.Last.Timestamp.Time); checkpointAge > options.MaxCheckpointAge {
+					nbTransformBehind++
+					transformsNotOk[transformStat.ID] = true
+					messages = append(messages, fmt.Sprintf("Transform %s is behind by %s since checkpoint %d", transformStat.ID, checkpointAge.Truncate(time.Second), transformStat.Checkpointing.Last.Checkpoint))
+				}
+			}
+		}
 	}
 
-	monitoringData.AddPerfdata("nbTransformFailed", nbTranformFailed, "")
+	if options.FailuresThresholds != nil {
+		if err = saveState(options.StateFile, currentState); err != nil {
+			return nil, err
+		}
+	}
+
+	status := thresholds.Status(float64(nbTranformFailed))
+	status = int(math.Max(float64(status), float64(thresholds.Status(float64(nbTransformBehind)))))
+	if nbTransformDegraded > 0 {
+		status = int(math.Max(float64(status), float64(nagiosPlugin.STATUS_WARNING)))
+	}
+	if nbTransformStoppedContinuous > 0 {
+		status = int(math.Max(float64(status), float64(options.StoppedSeverity)))
+	}
+	if options.FailuresThresholds != nil {
+		status = int(math.Max(float64(status), float64(options.FailuresThresholds.Status(float64(nbFailures)))))
+	}
+	monitoringData.SetStatus(status)
+	monitoringData.AddPerfdata("nbTransform", nbTransform, "")
+	monitoringData.AddPerfdataWithThresholds("nbTransformFailed", nbTranformFailed, "", thresholds)
 	monitoringData.AddPerfdata("nbTransformStopped", nbTransformStopped, "")
 	monitoringData.AddPerfdata("nbTransformStarted", nbTransformStarted, "")
+	monitoringData.AddPerfdata("nbTransformDegraded", nbTransformDegraded, "")
+	if options.MaxOperationsBehind > 0 || options.MaxCheckpointAge > 0 {
+		monitoringData.AddPerfdataWithThresholds("nbTransformBehind", nbTransformBehind, "", thresholds)
+	}
+	if options.FailuresThresholds != nil {
+		monitoringData.AddPerfdataWithThresholds("nbFailuresSinceLastRun", int(nbFailures), "", options.FailuresThresholds)
+	}
 
-	if monitoringData.Status() == nagiosPlugin.STATUS_OK {
-		if transformName == "_all" || transformName == "*" {
-			monitoringData.AddMessage("All transform works fine")
-		} else {
-			monitoringData.AddMessage("Transform %s works fine", transformName)
-		}
+	switch {
+	case len(transformsNotOk) > 0:
+		monitoringData.AddMessage("Some transforms are not ok (%d/%d)", nbTransform-len(transformsNotOk), nbTransform)
+	case transformName == "_all" || transformName == "*":
+		monitoringData.AddMessage("All transform works fine (%d/%d)", nbTransform, transformStats.Count)
+	default:
+		monitoringData.AddMessage("Transform %s works fine", transformName)
+	}
+	for _, message := range messages {
+		monitoringData.AddMessage(message)
 	}
 
 	return monitoringData, nil
 }
+
+// getTransformStats return the stats of all transforms that match transformName, page by page until count is reached.
+// It return nil if transform not found on first page, and the stats already read if it is not found on next pages
+func (h *CheckES) getTransformStats(ctx context.Context, transformName string) (*TransformStatsData, error) {
+
+	transformStats := &TransformStatsData{}
+	for from := 0; from == 0 || from < transformStats.Count; from += transformPageSize {
+		page, err := h.getTransformStatsPage(ctx, transformName, from)
+		if err != nil {
+			return nil, err
+		}
+		if page == nil {
+			if from == 0 {
+				return nil, nil
+			}
+			// The transforms can be deleted between two pages, so keep the stats already read
+			log.Debugf("Transform stats %s not found from %d, stop pagination", transformName, from)
+			transformStats.Count = len(transformStats.TransformStats)
+			break
+		}
+		transformStats.Count = page.Count
+		transformStats.TransformStats = append(transformStats.TransformStats, page.TransformStats...)
+
+		// The transforms can be deleted between two pages
+		if len(page.TransformStats) == 0 {
+			break
+		}
+	}
+
+	return transformStats, nil
+}
+
+// getTransformStatsPage return one page of transform stats. It return nil if transform not found
+func (h *CheckES) getTransformStatsPage(ctx context.Context, transformName string, from int) (*TransformStatsData, error) {
+
+	res, err := h.client.API.TransformGetTransformStats(
+		transformName,
+		h.client.API.TransformGetTransformStats.WithContext(ctx),
+		h.client.API.TransformGetTransformStats.WithFrom(from),
+		h.client.API.TransformGetTransformStats.WithSize(transformPageSize),
+		h.client.API.TransformGetTransformStats.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get Transform stats %s", transformName)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		if res.StatusCode == 404 {
+			return nil, nil
+		}
+		return nil, errors.Errorf("Error when get Transform stats %s: %s", transformName, res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get Transform stats %s from %d successfully:\n%s", transformName, from, string(b))
+
+	transformStats := &TransformStatsData{}
+	if err = json.Unmarshal(b, transformStats); err != nil {
+		return nil, err
+	}
+
+	return transformStats, nil
+}
+
+// getContinuousTransforms return the transforms that have sync settings, page by page until count is reached
+func (h *CheckES) getContinuousTransforms(ctx context.Context, transformName string) (map[string]bool, error) {
+
+	continuousTransforms := make(map[string]bool)
+	count := 0
+	for from := 0; from == 0 || from < count; from += transformPageSize {
+		transforms, err := h.getTransformsPage(ctx, transformName, from)
+		if err != nil {
+			return nil, err
+		}
+		count = transforms.Count
+		for _, transform := range transforms.Transforms {
+			if len(transform.Sync) > 0 {
+				continuousTransforms[transform.ID] = true
+			}
+		}
+
+		// The transforms can be deleted between two pages
+		if len(transforms.Transforms) == 0 {
+			break
+		}
+	}
+
+	return continuousTransforms, nil
+}
+
+// getTransformsPage return one page of transforms
+func (h *CheckES) getTransformsPage(ctx context.Context, transformName string, from int) (*TransformsData, error) {
+
+	res, err := h.client.API.TransformGetTransform(
+		h.client.API.TransformGetTransform.WithTransformID(transformName),
+		h.client.API.TransformGetTransform.WithContext(ctx),
+		h.client.API.TransformGetTransform.WithFrom(from),
+		h.client.API.TransformGetTransform.WithSize(transformPageSize),
+		h.client.API.TransformGetTransform.WithPretty(),
+	)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when get Transform %s", transformName)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return nil, errors.Errorf("Error when get Transform %s: %s", transformName, res.String())
+	}
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+	log.Debugf("Get Transform %s from %d successfully:\n%s", transformName, from, string(b))
+
+	transforms := &TransformsData{}
+	if err = json.Unmarshal(b, transforms); err != nil {
+		return nil, err
+	}
+
+	return transforms, nil
+}
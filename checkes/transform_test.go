@@ -1,28 +1,142 @@
 package checkes
 
 import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	nagiosPlugin "github.com/disaster37/go-nagios"
 	"github.com/stretchr/testify/assert"
 )
 
 func (s *CheckESTestSuite) TestCheckTransformError() {
 
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(s.T(), err)
+
 	// When check all transform
-	monitoringData, err := s.monitorES.CheckTransformError("_all", []string{})
+	monitoringData, err := s.monitorES.CheckTransformError(context.Background(), "_all", nil, nil, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When check all indices with exclude
-	monitoringData, err = s.monitorES.CheckTransformError("_all", []string{"foo"})
+	transformFilter, err := filter.New(nil, []string{"foo", "re:^tmp-"})
+	assert.NoError(s.T(), err)
+	monitoringData, err = s.monitorES.CheckTransformError(context.Background(), "_all", transformFilter, nil, thresholds)
+	assert.NoError(s.T(), err)
+	assert.NotNil(s.T(), monitoringData)
+	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
+
+	// When check lag, stopped transforms and failures
+	failuresThresholds, err := NewThresholds("0", "")
+	assert.NoError(s.T(), err)
+	options := &TransformCheckOptions{
+		MaxOperationsBehind: 1000,
+		MaxCheckpointAge:    1 * time.Hour,
+		StoppedSeverity:     nagiosPlugin.STATUS_WARNING,
+		FailuresThresholds:  failuresThresholds,
+		StateFile:           filepath.Join(s.T().TempDir(), "transform.json"),
+	}
+	monitoringData, err = s.monitorES.CheckTransformError(context.Background(), "_all", nil, options, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_OK, monitoringData.Status())
 
 	// When check transform that not exist
-	monitoringData, err = s.monitorES.CheckTransformError("foo", []string{})
+	monitoringData, err = s.monitorES.CheckTransformError(context.Background(), "foo", nil, nil, thresholds)
 	assert.NoError(s.T(), err)
 	assert.NotNil(s.T(), monitoringData)
 	assert.Equal(s.T(), nagiosPlugin.STATUS_UNKNOWN, monitoringData.Status())
 
 }
+
+func TestCheckTransformErrorPagination(t *testing.T) {
+
+	// The last transforms are on second page
+	nbTransforms := transformPageSize + 2
+	pages := make(map[string][]int)
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+		pages[r.URL.Path] = append(pages[r.URL.Path], from)
+		isStats := strings.HasSuffix(r.URL.Path, "/_stats")
+		transforms := make([]map[string]interface{}, 0, size)
+		for i := from; i < from+size && i < nbTransforms; i++ {
+			transform := map[string]interface{}{"id": fmt.Sprintf("transform-%04d", i)}
+			switch {
+			case isStats && i == nbTransforms-1:
+				transform["state"] = "failed"
+				transform["reason"] = "task failed"
+			case isStats && i == nbTransforms-2:
+				transform["state"] = "stopped"
+			case isStats:
+				transform["state"] = "started"
+			case i == nbTransforms-2:
+				transform["sync"] = map[string]interface{}{"time": map[string]interface{}{"field": "@timestamp"}}
+			}
+			transforms = append(transforms, transform)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": nbTransforms, "transforms": transforms})
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	thresholds, err := NewThresholds("", "0")
+	assert.NoError(t, err)
+
+	monitoringData, err := monitorES.CheckTransformError(context.Background(), "_all", nil, &TransformCheckOptions{StoppedSeverity: nagiosPlugin.STATUS_WARNING}, thresholds)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{0, transformPageSize}, pages["/_transform/_all/_stats"])
+	assert.Equal(t, []int{0, transformPageSize}, pages["/_transform/_all"])
+	assert.Equal(t, nagiosPlugin.STATUS_CRITICAL, monitoringData.Status())
+	assert.Equal(t, []string{
+		fmt.Sprintf("Some transforms are not ok (%d/%d)", nbTransforms-2, nbTransforms),
+		fmt.Sprintf("Continuous transform transform-%04d is stopped", nbTransforms-2),
+		fmt.Sprintf("Transform transform-%04d failed: task failed", nbTransforms-1),
+	}, monitoringData.Messages())
+	result := monitoringData.ToResult("check-transform")
+	assert.Equal(t, "nbTransform", result.Perfdatas[0].Label)
+	assert.Equal(t, nbTransforms, result.Perfdatas[0].Value)
+	assert.Equal(t, "nbTransformFailed", result.Perfdatas[1].Label)
+	assert.Equal(t, 1, result.Perfdatas[1].Value)
+}
+
+func TestGetTransformStatsNotFoundOnNextPage(t *testing.T) {
+
+	// The transforms are deleted before the second page
+	nbTransforms := transformPageSize + 2
+	server := newTestESServer(func(w http.ResponseWriter, r *http.Request) {
+		from, _ := strconv.Atoi(r.URL.Query().Get("from"))
+		if from > 0 {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error":{"type":"resource_not_found_exception"},"status":404}`))
+			return
+		}
+		transforms := make([]map[string]interface{}, 0, transformPageSize)
+		for i := 0; i < transformPageSize; i++ {
+			transforms = append(transforms, map[string]interface{}{"id": fmt.Sprintf("transform-%04d", i), "state": "started"})
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"count": nbTransforms, "transforms": transforms})
+	})
+	defer server.Close()
+	monitorES, err := NewCheckES(server.URL, "", "", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformStats, err := monitorES.(*CheckES).getTransformStats(context.Background(), "_all")
+	assert.NoError(t, err)
+	assert.NotNil(t, transformStats)
+	assert.Equal(t, transformPageSize, transformStats.Count)
+	assert.Len(t, transformStats.TransformStats, transformPageSize)
+}
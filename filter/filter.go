@@ -0,0 +1,132 @@
+// Package filter select the names of indices, transforms, snapshots or policies from include and exclude patterns.
+//
+// A pattern is a glob pattern, like `logs-debug-*`, or a regular expression when it is prefixed by `re:`, like `re:^tmp-.*`.
+// A name without wildcard is matched exactly.
+package filter
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// RegexPrefix is the prefix of patterns that are regular expressions
+const RegexPrefix = "re:"
+
+// Filter select names that match one of includes patterns, or all names when there are no includes patterns, and that not match any excludes patterns.
+// A nil filter select all names.
+type Filter struct {
+	includePatterns []string
+	excludePatterns []string
+	includes        []matcher
+	excludes        []matcher
+}
+
+type matcher interface {
+	MatchString(name string) bool
+}
+
+// globMatcher match name with glob pattern
+type globMatcher string
+
+// MatchString return true if name match the glob pattern
+func (g globMatcher) MatchString(name string) bool {
+	isMatch, _ := path.Match(string(g), name)
+	return isMatch
+}
+
+// New return filter from includes and excludes patterns
+func New(includes []string, excludes []string) (*Filter, error) {
+	includeMatchers, err := newMatchers(includes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when parse include patterns")
+	}
+	excludeMatchers, err := newMatchers(excludes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Error when parse exclude patterns")
+	}
+
+	return &Filter{
+		includePatterns: includes,
+		excludePatterns: excludes,
+		includes:        includeMatchers,
+		excludes:        excludeMatchers,
+	}, nil
+}
+
+// Match return true if name is selected by filter
+func (f *Filter) Match(name string) bool {
+	if f == nil {
+		return true
+	}
+
+	if len(f.includes) > 0 && !matchAny(f.includes, name) {
+		return false
+	}
+
+	return !matchAny(f.excludes, name)
+}
+
+// String return the includes and excludes patterns
+func (f *Filter) String() string {
+	if f == nil {
+		return "include: [], exclude: []"
+	}
+	return fmt.Sprintf("include: %v, exclude: %v", f.includePatterns, f.excludePatterns)
+}
+
+func newMatchers(patterns []string) ([]matcher, error) {
+	matchers := make([]matcher, 0, len(patterns))
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if strings.HasPrefix(pattern, RegexPrefix) {
+			regex, err := regexp.Compile(strings.TrimPrefix(pattern, RegexPrefix))
+			if err != nil {
+				return nil, errors.Wrapf(err, "Error when compile regex %s", pattern)
+			}
+			matchers = append(matchers, regex)
+			continue
+		}
+
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errors.Wrapf(err, "Error when parse glob pattern %s", pattern)
+		}
+		matchers = append(matchers, globMatcher(pattern))
+	}
+
+	return matchers, nil
+}
+
+func matchAny(matchers []matcher, name string) bool {
+	for _, m := range matchers {
+		if m.MatchString(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Patterns is the flag value of include or exclude patterns.
+// Each value is one pattern: it is not split on comma, so regular expression like `re:^a{1,3}$` is kept as is
+type Patterns []string
+
+// Set add the pattern
+func (p *Patterns) Set(pattern string) error {
+	*p = append(*p, pattern)
+	return nil
+}
+
+// String return the patterns separated by space
+func (p *Patterns) String() string {
+	if p == nil {
+		return ""
+	}
+	return strings.Join(*p, " ")
+}
@@ -0,0 +1,63 @@
+package filter
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter(t *testing.T) {
+
+	// When filter is nil
+	var f *Filter
+	assert.True(t, f.Match("foo"))
+
+	// When there are no patterns
+	f, err := New(nil, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Match("foo"))
+
+	// When exclude exact name, glob and regex
+	f, err = New(nil, []string{"foo", "logs-debug-*", "re:^tmp-.*"})
+	assert.NoError(t, err)
+	assert.False(t, f.Match("foo"))
+	assert.True(t, f.Match("foo2"))
+	assert.False(t, f.Match("logs-debug-2022.01.01-000001"))
+	assert.True(t, f.Match("logs-app-2022.01.01-000001"))
+	assert.False(t, f.Match("tmp-foo"))
+	assert.True(t, f.Match("my-tmp-foo"))
+
+	// When include and exclude
+	f, err = New([]string{"logs-*", "re:^metrics-(app|system)$"}, []string{"logs-debug-*"})
+	assert.NoError(t, err)
+	assert.True(t, f.Match("logs-app"))
+	assert.False(t, f.Match("logs-debug-app"))
+	assert.True(t, f.Match("metrics-app"))
+	assert.False(t, f.Match("metrics-other"))
+	assert.False(t, f.Match("foo"))
+
+	// When regex is invalid
+	_, err = New([]string{"re:("}, nil)
+	assert.Error(t, err)
+
+	// When glob is invalid
+	_, err = New(nil, []string{"[a-"})
+	assert.Error(t, err)
+}
+
+func TestPatterns(t *testing.T) {
+
+	patterns := &Patterns{}
+	assert.Equal(t, "", patterns.String())
+
+	// When pattern has comma, it is not split
+	assert.NoError(t, patterns.Set("re:^a{1,3}$"))
+	assert.NoError(t, patterns.Set("logs-*"))
+	assert.Equal(t, Patterns{"re:^a{1,3}$", "logs-*"}, *patterns)
+	assert.Equal(t, "re:^a{1,3}$ logs-*", patterns.String())
+
+	f, err := New(*patterns, nil)
+	assert.NoError(t, err)
+	assert.True(t, f.Match("aaa"))
+	assert.False(t, f.Match("aaaa"))
+}
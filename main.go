@@ -3,8 +3,11 @@ package main
 import (
 	"os"
 	"sort"
+	"strings"
+	"time"
 
 	"github.com/disaster37/check_elasticsearch/v7/checkes"
+	"github.com/disaster37/check_elasticsearch/v7/filter"
 	"github.com/disaster37/go-nagios"
 	log "github.com/sirupsen/logrus"
 	"github.com/urfave/cli/v2"
@@ -21,6 +24,12 @@ func run(args []string) error {
 	log.SetFormatter(formatter)
 	log.SetOutput(os.Stdout)
 
+	return newApp().Run(args)
+}
+
+// newApp return the CLI application with all commands
+func newApp() *cli.App {
+
 	// CLI settings
 	app := cli.NewApp()
 	app.Usage = "Check Elasticsearch"
@@ -30,11 +39,15 @@ func run(args []string) error {
 			Name:  "config",
 			Usage: "Load configuration from `FILE`",
 		},
-		altsrc.NewStringFlag(&cli.StringFlag{
+		altsrc.NewStringSliceFlag(&cli.StringSliceFlag{
 			Name:    "url",
-			Usage:   "The Elasticsearch URL",
+			Usage:   "The Elasticsearch URL. Repeat it or use comma separated list to set several nodes",
 			EnvVars: []string{"ELASTICSEARCH_URL"},
 		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
+			Name:  "sniff",
+			Usage: "Discover the other nodes of the cluster from the URLs",
+		}),
 		altsrc.NewStringFlag(&cli.StringFlag{
 			Name:    "user",
 			Usage:   "The Elasticsearch user",
@@ -45,10 +58,65 @@ func run(args []string) error {
 			Usage:   "The Elasticsearch password",
 			EnvVars: []string{"ELASTICSEARCH_PASSWORD"},
 		}),
-		&cli.BoolFlag{
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "api-key",
+			Usage:   "The Elasticsearch API key, as id:key or base64 encoded",
+			EnvVars: []string{"ELASTICSEARCH_API_KEY"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "service-token",
+			Usage:   "The Elasticsearch service token, sent as bearer token",
+			EnvVars: []string{"ELASTICSEARCH_SERVICE_TOKEN"},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:    "cloud-id",
+			Usage:   "The Elastic Cloud ID, instead of --url",
+			EnvVars: []string{"ELASTICSEARCH_CLOUD_ID"},
+		}),
+		altsrc.NewBoolFlag(&cli.BoolFlag{
 			Name:  "self-signed-certificate",
 			Usage: "Disable the TLS certificate check",
-		},
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "ca-cert",
+			Usage: "The PEM `FILE` or directory of PEM files of the CA certificates to trust",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "client-cert",
+			Usage: "The PEM `FILE` of the client certificate for mutual TLS",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "client-key",
+			Usage: "The PEM `FILE` of the client key for mutual TLS",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "tls-server-name",
+			Usage: "The server name used to verify the Elasticsearch certificate",
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "ca-fingerprint",
+			Usage: "The SHA-256 fingerprint of the CA certificate to trust, as hex string. It can't be used with --ca-cert",
+		}),
+		altsrc.NewDurationFlag(&cli.DurationFlag{
+			Name:  "timeout",
+			Usage: "The maximum duration of check. Set 0 to disable it",
+			Value: 30 * time.Second,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "timeout-status",
+			Usage: "The status returned when check timeout: ok, warning, critical or unknown",
+			Value: "unknown",
+		}),
+		altsrc.NewIntFlag(&cli.IntFlag{
+			Name:  "retries",
+			Usage: "The number of retries when Elasticsearch return 429, 502, 503 or 504 status. Unreachable nodes are always retried on the other URLs",
+			Value: 3,
+		}),
+		altsrc.NewStringFlag(&cli.StringFlag{
+			Name:  "output",
+			Usage: "The output format of check result: nagios, json or passive (only for run-batch)",
+			Value: "nagios",
+		}),
 		&cli.BoolFlag{
 			Name:  "debug",
 			Usage: "Display debug output",
@@ -64,9 +132,51 @@ func run(args []string) error {
 					Name:  "indice",
 					Usage: "The indice name",
 				},
-				&cli.StringSliceFlag{
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The indices to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
 					Name:  "exclude",
-					Usage: "The indice name to exclude",
+					Usage: "The indices to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of failed indices, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of failed indices, as Nagios range",
+					Value: "0",
+				},
+				&cli.BoolFlag{
+					Name:  "auto-retry",
+					Usage: "Retry ILM on failed indices",
+				},
+				&cli.IntFlag{
+					Name:  "max-retries-per-run",
+					Usage: "The maximum number of ILM retries issued by run",
+					Value: 10,
+				},
+				&cli.IntFlag{
+					Name:  "max-retries-per-indice",
+					Usage: "The maximum number of ILM retries issued on the same indice before its retries expire",
+					Value: 3,
+				},
+				&cli.DurationFlag{
+					Name:  "retries-expiration",
+					Usage: "The time after the last ILM retry on indice before its retries are forgotten",
+					Value: 24 * time.Hour,
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Display the ILM retries without issue them",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "The `FILE` where to store the ILM retry attempts",
 				},
 			},
 			Action: checkes.CheckILMError,
@@ -77,6 +187,63 @@ func run(args []string) error {
 			Category: "ILM",
 			Action:   checkes.CheckILMStatus,
 		},
+		{
+			Name:     "check-ilm-stuck",
+			Usage:    "Check that there are no indice stuck on the same ILM step. Set indice _all to check all indices",
+			Category: "ILM",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "indice",
+					Usage: "The indice name",
+				},
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The indices to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
+					Name:  "exclude",
+					Usage: "The indices to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.DurationFlag{
+					Name:  "max-step-age",
+					Usage: "The maximum time an indice can stay on the same ILM step. On check-rollover-ready step, the rollover max_age of policy is added",
+					Value: 24 * time.Hour,
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of stuck indices, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of stuck indices, as Nagios range",
+					Value: "0",
+				},
+			},
+			Action: checkes.CheckILMStuck,
+		},
+		{
+			Name:     "check-repository-verify",
+			Usage:    "Check that snapshot repositories are reachable from all nodes",
+			Category: "SLM",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "repository",
+					Usage: "The repository name or empty for check all repositories",
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of repositories that failed verification, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of repositories that failed verification, as Nagios range",
+					Value: "0",
+				},
+			},
+			Action: checkes.CheckRepositoryVerify,
+		},
 		{
 			Name:     "check-repository-snapshot",
 			Usage:    "Check snapshots state on repository",
@@ -86,6 +253,50 @@ func run(args []string) error {
 					Name:  "repository",
 					Usage: "The repisitory name",
 				},
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The snapshots to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
+					Name:  "exclude",
+					Usage: "The snapshots to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of failed snapshots, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of failed snapshots, as Nagios range",
+					Value: "0",
+				},
+				&cli.DurationFlag{
+					Name:  "warning-max-age",
+					Usage: "The maximum age of the newest successful snapshot before warning",
+				},
+				&cli.DurationFlag{
+					Name:  "max-age",
+					Usage: "The maximum age of the newest successful snapshot before critical",
+				},
+				&cli.IntFlag{
+					Name:  "last",
+					Usage: "Only check the failures of the last N snapshots",
+				},
+				&cli.DurationFlag{
+					Name:  "since",
+					Usage: "Only check the failures of snapshots started since this duration",
+				},
+				&cli.BoolFlag{
+					Name:  "ignore-superseded",
+					Usage: "Ignore the failed snapshots followed by a successful snapshot",
+				},
+				&cli.StringFlag{
+					Name:  "partial-severity",
+					Usage: "The maximum status raised by partial snapshots: critical, warning or ignore",
+					Value: "critical",
+				},
 			},
 			Action: checkes.CheckSLMError,
 		},
@@ -98,6 +309,39 @@ func run(args []string) error {
 					Name:  "name",
 					Usage: "The policy name",
 				},
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The policies to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
+					Name:  "exclude",
+					Usage: "The policies to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of failed policies, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of failed policies, as Nagios range",
+					Value: "0",
+				},
+				&cli.Float64Flag{
+					Name:  "schedule-tolerance",
+					Usage: "The factor applied on the time between two scheduled runs to get the time a run has to succeed. 0 disable the missed schedule check",
+					Value: 1.5,
+				},
+				&cli.DurationFlag{
+					Name:  "max-running",
+					Usage: "The maximum duration of in progress snapshot. 0 disable the check",
+				},
+				&cli.StringFlag{
+					Name:  "never-succeeded-severity",
+					Usage: "The maximum status raised by policies that never succeeded: ignore, warning or critical",
+					Value: "warning",
+				},
 			},
 			Action: checkes.CheckSLMPolicy,
 		},
@@ -107,6 +351,36 @@ func run(args []string) error {
 			Category: "SLM",
 			Action:   checkes.CheckSLMStatus,
 		},
+		{
+			Name:     "check-slm-retention",
+			Usage:    "Check that SLM retention and snapshot deletions not failed since last run",
+			Category: "SLM",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of failed or timed out retention runs since last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of failed or timed out retention runs since last run, as Nagios range",
+					Value: "0",
+				},
+				&cli.StringFlag{
+					Name:  "warning-deletion-failures",
+					Usage: "The warning threshold on the number of snapshot deletion failures since last run, as Nagios range",
+					Value: "0",
+				},
+				&cli.StringFlag{
+					Name:  "critical-deletion-failures",
+					Usage: "The critical threshold on the number of snapshot deletion failures since last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "The `FILE` where to store the SLM counters between two runs",
+				},
+			},
+			Action: checkes.CheckSLMRetention,
+		},
 		{
 			Name:     "check-indice-locked",
 			Usage:    "Check if there are indice locked. You can use _all as indice name to check all indices",
@@ -116,6 +390,38 @@ func run(args []string) error {
 					Name:  "indice",
 					Usage: "The indice name",
 				},
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The indices to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
+					Name:  "exclude",
+					Usage: "The indices to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.StringSliceFlag{
+					Name:  "blocks",
+					Usage: "The blocks to check with their maximum severity (critical, warning or ignore), like read_only_allow_delete=critical,write=warning",
+					Value: cli.NewStringSlice("read_only_allow_delete=critical"),
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of locked indices, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of locked indices, as Nagios range",
+					Value: "0",
+				},
+				&cli.BoolFlag{
+					Name:  "auto-unlock",
+					Usage: "Unlock the indices when all nodes that hold their shards are below the high watermark, need the read_only_allow_delete block",
+				},
+				&cli.BoolFlag{
+					Name:  "dry-run",
+					Usage: "Display the indices to unlock without unlock them",
+				},
 			},
 			Action: checkes.CheckIndiceLocked,
 		},
@@ -128,13 +434,237 @@ func run(args []string) error {
 					Name:  "name",
 					Usage: "The transform id or empty for check all transform",
 				},
-				&cli.StringSliceFlag{
+				&cli.GenericFlag{
+					Name:  "include",
+					Usage: "The transforms to include, as name, glob pattern like logs-* or regex prefixed by re:. Default to all",
+					Value: &filter.Patterns{},
+				},
+				&cli.GenericFlag{
 					Name:  "exclude",
-					Usage: "The transform id to exclude",
+					Usage: "The transforms to exclude, as name, glob pattern like logs-debug-* or regex prefixed by re:",
+					Value: &filter.Patterns{},
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of failed transforms and on the number of continuous transforms behind, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of failed transforms and on the number of continuous transforms behind, as Nagios range",
+					Value: "0",
+				},
+				&cli.Int64Flag{
+					Name:  "max-operations-behind",
+					Usage: "The maximum number of operations behind of continuous transforms. 0 disable the check",
+				},
+				&cli.DurationFlag{
+					Name:  "max-checkpoint-age",
+					Usage: "The maximum age of last checkpoint of continuous transforms with operations behind. 0 disable the check",
+				},
+				&cli.StringFlag{
+					Name:  "stopped-severity",
+					Usage: "The maximum status raised by stopped continuous transforms: ignore, warning or critical",
+					Value: "ignore",
+				},
+				&cli.StringFlag{
+					Name:  "warning-failures",
+					Usage: "The warning threshold on the number of search and index failures since last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical-failures",
+					Usage: "The critical threshold on the number of search and index failures since last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "The file where to store the failures counters between two runs. Default to a file on temporary directory",
 				},
 			},
 			Action: checkes.CheckTransformError,
 		},
+		{
+			Name:     "check-cluster-health",
+			Usage:    "Check the cluster health and the number of nodes",
+			Category: "Cluster",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "min-nodes",
+					Usage: "The minimum number of nodes expected. 0 to disable it",
+				},
+				&cli.IntFlag{
+					Name:  "min-data-nodes",
+					Usage: "The minimum number of data nodes expected. 0 to disable it",
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of unassigned shards, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of unassigned shards, as Nagios range",
+				},
+			},
+			Action: checkes.CheckClusterHealth,
+		},
+		{
+			Name:     "check-unassigned-shards",
+			Usage:    "Check that there are no unassigned shards and explain why",
+			Category: "Cluster",
+			Flags: []cli.Flag{
+				&cli.IntFlag{
+					Name:  "max-explain",
+					Usage: "The maximum number of unassigned shards to explain",
+					Value: 10,
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the number of unassigned shards, as Nagios range. Default to warning when replica shards are unassigned",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the number of unassigned shards, as Nagios range. Unassigned primary shards are always critical",
+				},
+			},
+			Action: checkes.CheckUnassignedShards,
+		},
+		{
+			Name:     "check-node-disk",
+			Usage:    "Check the disk usage of data nodes against the cluster disk watermarks",
+			Category: "Node",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the disk used percent, as Nagios range. It replace the disk watermarks",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the disk used percent, as Nagios range. It replace the disk watermarks",
+				},
+			},
+			Action: checkes.CheckNodeDisk,
+		},
+		{
+			Name:     "check-node-jvm",
+			Usage:    "Check the JVM heap, the old GC and the circuit breakers of each node",
+			Category: "Node",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the heap used percent, as Nagios range",
+					Value: "85",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the heap used percent, as Nagios range",
+					Value: "95",
+				},
+				&cli.StringFlag{
+					Name:  "warning-gc-rate",
+					Usage: "The warning threshold on the number of old GC per minute since the last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical-gc-rate",
+					Usage: "The critical threshold on the number of old GC per minute since the last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "warning-breaker-tripped",
+					Usage: "The warning threshold on the number of circuit breakers tripped since the last run, as Nagios range",
+					Value: "0",
+				},
+				&cli.StringFlag{
+					Name:  "critical-breaker-tripped",
+					Usage: "The critical threshold on the number of circuit breakers tripped since the last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "The file where to store the counters between two runs. Default to a file on temporary directory",
+				},
+			},
+			Action: checkes.CheckNodeJVM,
+		},
+		{
+			Name:     "check-thread-pool",
+			Usage:    "Check the queue size and the rejections of thread pools on each node",
+			Category: "Node",
+			Flags: []cli.Flag{
+				&cli.StringSliceFlag{
+					Name:  "pool",
+					Usage: "The thread pool names to check",
+					Value: cli.NewStringSlice("write", "search", "get"),
+				},
+				&cli.StringFlag{
+					Name:  "warning",
+					Usage: "The warning threshold on the queue size, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "critical",
+					Usage: "The critical threshold on the queue size, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "warning-rejected",
+					Usage: "The warning threshold on the number of rejections since the last run, as Nagios range",
+					Value: "0",
+				},
+				&cli.StringFlag{
+					Name:  "critical-rejected",
+					Usage: "The critical threshold on the number of rejections since the last run, as Nagios range",
+				},
+				&cli.StringFlag{
+					Name:  "state-file",
+					Usage: "The file where to store the counters between two runs. Default to a file on temporary directory",
+				},
+			},
+			Action: checkes.CheckThreadPool,
+		},
+		{
+			Name:     "serve",
+			Usage:    "Run the checks periodically and expose the results as Prometheus metrics",
+			Category: "Daemon",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "checks",
+					Usage:    "Load the checks to run from `FILE`",
+					Required: true,
+				},
+				&cli.StringFlag{
+					Name:  "listen",
+					Usage: "The address where to listen HTTP requests",
+					Value: ":9400",
+				},
+				&cli.DurationFlag{
+					Name:  "interval",
+					Usage: "The interval between two runs of checks",
+					Value: 1 * time.Minute,
+				},
+				&cli.IntFlag{
+					Name:  "workers",
+					Usage: "The number of checks run at the same time",
+					Value: 4,
+				},
+			},
+			Action: serve,
+		},
+		{
+			Name:     "run-batch",
+			Usage:    "Run all checks declared on checks file and print one result per check",
+			Category: "Daemon",
+			Flags: []cli.Flag{
+				&cli.StringFlag{
+					Name:     "checks",
+					Usage:    "Load the checks to run from `FILE`",
+					Required: true,
+				},
+				&cli.IntFlag{
+					Name:  "workers",
+					Usage: "The number of checks run at the same time",
+					Value: 4,
+				},
+				&cli.StringFlag{
+					Name:  "host-name",
+					Usage: "The Nagios host name used on passive output",
+				},
+			},
+			Action: runBatch,
+		},
 	}
 
 	app.Before = func(c *cli.Context) error {
@@ -150,10 +680,16 @@ func run(args []string) error {
 		return nil
 	}
 
+	// Handle the duration and the errors of checks
+	for _, command := range app.Commands {
+		if strings.HasPrefix(command.Name, "check-") {
+			command.Action = checkes.WrapCheckAction(command.Action)
+		}
+	}
+
 	sort.Sort(cli.CommandsByName(app.Commands))
 
-	err := app.Run(args)
-	return err
+	return app
 }
 
 func main() {
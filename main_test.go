@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/disaster37/check_elasticsearch/v7/filter"
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestFilterFlags(t *testing.T) {
+
+	var includes, excludes filter.Patterns
+	app := newApp()
+	for _, command := range app.Commands {
+		if command.Name == "check-indice-locked" {
+			command.Action = func(c *cli.Context) error {
+				includes = *c.Generic("include").(*filter.Patterns)
+				excludes = *c.Generic("exclude").(*filter.Patterns)
+				return nil
+			}
+		}
+	}
+
+	// The patterns are not split on comma
+	err := app.Run([]string{app.Name, "check-indice-locked", "--indice", "_all", "--include", "re:^a{1,3}$", "--include", "logs-*", "--exclude", "re:^b{2,}$"})
+	assert.NoError(t, err)
+	assert.Equal(t, filter.Patterns{"re:^a{1,3}$", "logs-*"}, includes)
+	assert.Equal(t, filter.Patterns{"re:^b{2,}$"}, excludes)
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/disaster37/check_elasticsearch/v7/checkes"
+	"github.com/disaster37/go-nagios"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+	"gopkg.in/yaml.v3"
+)
+
+// checkDefinition is a check declared on the checks file
+type checkDefinition struct {
+	Name     string   `yaml:"name"`
+	Command  string   `yaml:"command"`
+	Args     []string `yaml:"args,omitempty"`
+	Warning  *string  `yaml:"warning,omitempty"`
+	Critical *string  `yaml:"critical,omitempty"`
+}
+
+// checkResult is the result of check run
+type checkResult struct {
+	monitoringData *checkes.Monitoring
+	lastRun        time.Time
+	duration       time.Duration
+}
+
+// commandArgs return the command line arguments of check
+func (d checkDefinition) commandArgs() []string {
+	args := append([]string{d.Command}, d.Args...)
+	if d.Warning != nil {
+		args = append(args, "--warning", *d.Warning)
+	}
+	if d.Critical != nil {
+		args = append(args, "--critical", *d.Critical)
+	}
+
+	return args
+}
+
+// checksFile is the file that declare the checks to run
+type checksFile struct {
+	Checks []checkDefinition `yaml:"checks"`
+}
+
+// loadCheckDefinitions read the checks declared on checks file
+func loadCheckDefinitions(file string) ([]checkDefinition, error) {
+
+	if file == "" {
+		return nil, errors.New("You must set --checks parameter")
+	}
+
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Error when read checks file %s", file)
+	}
+	checks := &checksFile{}
+	if err = yaml.Unmarshal(b, checks); err != nil {
+		return nil, errors.Wrapf(err, "Error when decode checks file %s", file)
+	}
+
+	if len(checks.Checks) == 0 {
+		return nil, errors.Errorf("There are no check on checks file %s", file)
+	}
+	names := make(map[string]bool, len(checks.Checks))
+	for _, check := range checks.Checks {
+		if check.Name == "" {
+			return nil, errors.Errorf("All checks must have a name on checks file %s", file)
+		}
+		if names[check.Name] {
+			return nil, errors.Errorf("Check %s is declared twice on checks file %s", check.Name, file)
+		}
+		names[check.Name] = true
+		if !strings.HasPrefix(check.Command, "check-") {
+			return nil, errors.Errorf("Command %s of check %s is not a check command", check.Command, check.Name)
+		}
+	}
+
+	return checks.Checks, nil
+}
+
+// globalCheckArgs return the global parameters given to each check.
+// The cluster URLs or cloud ID are given to compute the default state file of stateful checks, the checks use the shared Elasticsearch client
+func globalCheckArgs(c *cli.Context) []string {
+	args := []string{
+		"--timeout", c.Duration("timeout").String(),
+		"--timeout-status", c.String("timeout-status"),
+	}
+	for _, url := range c.StringSlice("url") {
+		args = append(args, "--url", url)
+	}
+	if c.String("cloud-id") != "" {
+		args = append(args, "--cloud-id", c.String("cloud-id"))
+	}
+
+	return args
+}
+
+// runCheck run the check command on dedicated app that use the shared Elasticsearch client.
+// It return unknown monitoring data if check failed. The check is canceled when ctx is done
+func runCheck(ctx context.Context, monitorES checkes.MonitorES, check checkDefinition, globalArgs []string) *checkResult {
+
+	log.Debugf("Run check %s", check.Name)
+	start := time.Now()
+	monitoringData := runCheckCommand(ctx, monitorES, check, globalArgs)
+	result := &checkResult{
+		monitoringData: monitoringData,
+		lastRun:        start,
+		duration:       time.Since(start),
+	}
+	log.Debugf("Check %s finished in %s: %s", check.Name, result.duration, monitoringData.StatusName())
+
+	return result
+}
+
+// runCheckCommand run the check command on new app and catch the result
+func runCheckCommand(ctx context.Context, monitorES checkes.MonitorES, check checkDefinition, globalArgs []string) *checkes.Monitoring {
+
+	var monitoringData *checkes.Monitoring
+
+	app := newApp()
+	app.Writer = ioutil.Discard
+	app.ErrWriter = ioutil.Discard
+	app.ExitErrHandler = func(c *cli.Context, err error) {}
+	checkes.SetSharedMonitorES(app, monitorES)
+	checkes.SetResultHandler(app, func(result *checkes.Monitoring) {
+		monitoringData = result
+	})
+
+	args := append([]string{app.Name}, globalArgs...)
+	args = append(args, check.commandArgs()...)
+	if err := app.RunContext(ctx, args); err != nil {
+		return newErrorMonitoring(err)
+	}
+	if monitoringData == nil {
+		return newErrorMonitoring(errors.Errorf("Command %s not return result", check.Command))
+	}
+
+	return monitoringData
+}
+
+// newErrorMonitoring return unknown monitoring data for the error
+func newErrorMonitoring(err error) *checkes.Monitoring {
+	monitoringData := checkes.NewMonitoring()
+	monitoringData.SetStatus(nagiosPlugin.STATUS_UNKNOWN)
+	monitoringData.AddMessage("Error appear during check: %s", err)
+
+	return monitoringData
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/urfave/cli/v2"
+)
+
+func TestLoadCheckDefinitions(t *testing.T) {
+
+	dir := t.TempDir()
+	testCases := []struct {
+		name    string
+		content string
+		isError bool
+	}{
+		{
+			name: "valid",
+			content: `
+checks:
+  - name: health
+    command: check-cluster-health
+  - name: locked
+    command: check-indice-locked
+    args: ["--indice", "_all"]
+    critical: "0"
+`,
+		},
+		{
+			name:    "no checks",
+			content: `checks: []`,
+			isError: true,
+		},
+		{
+			name: "no name",
+			content: `
+checks:
+  - command: check-cluster-health
+`,
+			isError: true,
+		},
+		{
+			name: "duplicate name",
+			content: `
+checks:
+  - name: health
+    command: check-cluster-health
+  - name: health
+    command: check-node-disk
+`,
+			isError: true,
+		},
+		{
+			name: "not check command",
+			content: `
+checks:
+  - name: serve
+    command: serve
+`,
+			isError: true,
+		},
+		{
+			name:    "bad yaml",
+			content: `checks: [`,
+			isError: true,
+		},
+	}
+
+	for _, testCase := range testCases {
+		file := filepath.Join(dir, testCase.name+".yml")
+		if err := ioutil.WriteFile(file, []byte(testCase.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		checks, err := loadCheckDefinitions(file)
+		if testCase.isError {
+			assert.Error(t, err, testCase.name)
+			continue
+		}
+		assert.NoError(t, err, testCase.name)
+		assert.Len(t, checks, 2, testCase.name)
+	}
+
+	// When file is not set or not exist
+	_, err := loadCheckDefinitions("")
+	assert.Error(t, err)
+	_, err = loadCheckDefinitions(filepath.Join(dir, "foo.yml"))
+	assert.Error(t, err)
+}
+
+func TestCommandArgs(t *testing.T) {
+
+	warning := "5"
+	critical := "10"
+	testCases := []struct {
+		name     string
+		check    checkDefinition
+		expected []string
+	}{
+		{
+			name:     "without args",
+			check:    checkDefinition{Command: "check-cluster-health"},
+			expected: []string{"check-cluster-health"},
+		},
+		{
+			name:     "with args and thresholds",
+			check:    checkDefinition{Command: "check-indice-locked", Args: []string{"--indice", "_all"}, Warning: &warning, Critical: &critical},
+			expected: []string{"check-indice-locked", "--indice", "_all", "--warning", "5", "--critical", "10"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, testCase.check.commandArgs(), testCase.name)
+	}
+}
+
+func TestGlobalCheckArgs(t *testing.T) {
+
+	// Not read the cluster from environment
+	for _, envVar := range []string{"ELASTICSEARCH_URL", "ELASTICSEARCH_CLOUD_ID"} {
+		if value, ok := os.LookupEnv(envVar); ok {
+			os.Unsetenv(envVar)
+			defer os.Setenv(envVar, value)
+		}
+	}
+
+	testCases := []struct {
+		name     string
+		args     []string
+		expected []string
+	}{
+		{
+			name:     "with URLs",
+			args:     []string{"--url", "http://node1:9200", "--url", "http://node2:9200"},
+			expected: []string{"--timeout", "30s", "--timeout-status", "unknown", "--url", "http://node1:9200", "--url", "http://node2:9200"},
+		},
+		{
+			name:     "with cloud ID",
+			args:     []string{"--cloud-id", "test:abcd", "--timeout", "10s", "--timeout-status", "critical"},
+			expected: []string{"--timeout", "10s", "--timeout-status", "critical", "--cloud-id", "test:abcd"},
+		},
+	}
+
+	for _, testCase := range testCases {
+		var args []string
+		app := newApp()
+		app.Action = func(c *cli.Context) error {
+			args = globalCheckArgs(c)
+			return nil
+		}
+		err := app.Run(append([]string{app.Name}, testCase.args...))
+		assert.NoError(t, err, testCase.name)
+		assert.Equal(t, testCase.expected, args, testCase.name)
+	}
+}
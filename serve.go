@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/disaster37/check_elasticsearch/v7/checkes"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/urfave/cli/v2"
+)
+
+// checkServer run the checks periodically and serve the last results
+type checkServer struct {
+	monitorES  checkes.MonitorES
+	checks     []checkDefinition
+	globalArgs []string
+	interval   time.Duration
+	workers    int
+	results    map[string]*checkResult
+	running    map[string]bool
+	mutex      sync.RWMutex
+}
+
+// shutdownTimeout is the maximum time to wait the running HTTP requests on shutdown
+const shutdownTimeout = 10 * time.Second
+
+// serve run the checks periodically and expose the results over HTTP
+func serve(c *cli.Context) error {
+
+	if c.Duration("interval") <= 0 {
+		return errors.New("--interval parameter must be positive")
+	}
+	if c.Int("workers") <= 0 {
+		return errors.New("--workers parameter must be positive")
+	}
+
+	checks, err := loadCheckDefinitions(c.String("checks"))
+	if err != nil {
+		return err
+	}
+
+	monitorES, err := checkes.NewMonitorESFromContext(c)
+	if err != nil {
+		return err
+	}
+
+	server := &checkServer{
+		monitorES:  monitorES,
+		checks:     checks,
+		globalArgs: globalCheckArgs(c),
+		interval:   c.Duration("interval"),
+		workers:    c.Int("workers"),
+		results:    make(map[string]*checkResult, len(checks)),
+		running:    make(map[string]bool, len(checks)),
+	}
+
+	// Stop the checks and the HTTP server on SIGINT or SIGTERM
+	ctx, stop := signal.NotifyContext(c.Context, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server.start(ctx)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", server.handleMetrics)
+	mux.HandleFunc("/checks/", server.handleCheck)
+	httpServer := &http.Server{
+		Addr:    c.String("listen"),
+		Handler: mux,
+	}
+	go func() {
+		<-ctx.Done()
+		log.Info("Stop server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := httpServer.Shutdown(shutdownCtx); err != nil {
+			log.Errorf("Error when stop server: %s", err)
+		}
+	}()
+
+	log.Infof("Listen on %s with %d checks run every %s", c.String("listen"), len(checks), server.interval)
+	if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// start run the checks every interval on a bounded worker pool until ctx is done.
+// A check is skipped on a tick while its previous run is not finished
+func (s *checkServer) start(ctx context.Context) {
+	jobs := make(chan checkDefinition)
+	for i := 0; i < s.workers; i++ {
+		go func() {
+			for check := range jobs {
+				s.run(ctx, check)
+			}
+		}()
+	}
+
+	go func() {
+		defer close(jobs)
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.schedule(ctx, jobs)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// schedule send to workers the checks that are not running
+func (s *checkServer) schedule(ctx context.Context, jobs chan<- checkDefinition) {
+	for _, check := range s.checks {
+		if !s.markRunning(check.Name) {
+			log.Warnf("Check %s is still running, skip it", check.Name)
+			continue
+		}
+		select {
+		case jobs <- check:
+		case <-ctx.Done():
+			s.unmarkRunning(check.Name)
+			return
+		}
+	}
+}
+
+// markRunning mark the check as running. It return false if the check is already running
+func (s *checkServer) markRunning(name string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.running[name] {
+		return false
+	}
+	s.running[name] = true
+	return true
+}
+
+// unmarkRunning mark the check as not running
+func (s *checkServer) unmarkRunning(name string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	delete(s.running, name)
+}
+
+// run run the check and store the result
+func (s *checkServer) run(ctx context.Context, check checkDefinition) {
+	defer s.unmarkRunning(check.Name)
+	result := runCheck(ctx, s.monitorES, check, s.globalArgs)
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.results[check.Name] = result
+}
+
+// handleCheck return the Nagios output of check
+func (s *checkServer) handleCheck(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/checks/")
+
+	s.mutex.RLock()
+	result, ok := s.results[name]
+	s.mutex.RUnlock()
+
+	if !ok {
+		if s.isDeclared(name) {
+			http.Error(w, fmt.Sprintf("Check %s has not been run yet", name), http.StatusServiceUnavailable)
+			return
+		}
+		http.Error(w, fmt.Sprintf("Check %s not found", name), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintln(w, result.monitoringData.Output())
+}
+
+// handleMetrics return the last results as Prometheus metrics
+func (s *checkServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	names := make([]string, 0, len(s.results))
+	for name := range s.results {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var buffer strings.Builder
+	buffer.WriteString("# HELP check_elasticsearch_status The status of check (0: OK, 1: WARNING, 2: CRITICAL, 3: UNKNOWN)\n")
+	buffer.WriteString("# TYPE check_elasticsearch_status gauge\n")
+	for _, name := range names {
+		buffer.WriteString(fmt.Sprintf("check_elasticsearch_status{check=\"%s\"} %d\n", escapeLabelValue(name), s.results[name].monitoringData.Status()))
+	}
+	buffer.WriteString("# HELP check_elasticsearch_perfdata The perfdata of check\n")
+	buffer.WriteString("# TYPE check_elasticsearch_perfdata gauge\n")
+	for _, name := range names {
+		for _, perfdata := range s.results[name].monitoringData.Perfdatas() {
+			buffer.WriteString(fmt.Sprintf("check_elasticsearch_perfdata{check=\"%s\",label=\"%s\",unit=\"%s\"} %d\n", escapeLabelValue(name), escapeLabelValue(perfdata.Label()), escapeLabelValue(perfdata.Unit()), perfdata.Value()))
+		}
+	}
+	buffer.WriteString("# HELP check_elasticsearch_last_run_timestamp_seconds The timestamp of the last run of check\n")
+	buffer.WriteString("# TYPE check_elasticsearch_last_run_timestamp_seconds gauge\n")
+	for _, name := range names {
+		buffer.WriteString(fmt.Sprintf("check_elasticsearch_last_run_timestamp_seconds{check=\"%s\"} %d\n", escapeLabelValue(name), s.results[name].lastRun.Unix()))
+	}
+	buffer.WriteString("# HELP check_elasticsearch_duration_seconds The duration of the last run of check\n")
+	buffer.WriteString("# TYPE check_elasticsearch_duration_seconds gauge\n")
+	for _, name := range names {
+		buffer.WriteString(fmt.Sprintf("check_elasticsearch_duration_seconds{check=\"%s\"} %g\n", escapeLabelValue(name), s.results[name].duration.Seconds()))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	fmt.Fprint(w, buffer.String())
+}
+
+// isDeclared return true if check is declared
+func (s *checkServer) isDeclared(name string) bool {
+	for _, check := range s.checks {
+		if check.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeLabelValue escape the Prometheus label value
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/disaster37/check_elasticsearch/v7/checkes"
+	"github.com/disaster37/go-nagios"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCheckResult(status int, message string, lastRun time.Time) *checkResult {
+	monitoringData := checkes.NewMonitoring()
+	monitoringData.SetStatus(status)
+	monitoringData.AddMessage(message)
+	thresholds, _ := checkes.NewThresholds("", "0")
+	monitoringData.AddPerfdataWithThresholds("nbIndicesLocked", status, "", thresholds)
+
+	return &checkResult{
+		monitoringData: monitoringData,
+		lastRun:        lastRun,
+		duration:       1500 * time.Millisecond,
+	}
+}
+
+func TestHandleMetrics(t *testing.T) {
+
+	server := &checkServer{
+		results: map[string]*checkResult{
+			"locked":     newTestCheckResult(nagiosPlugin.STATUS_CRITICAL, "Some indices are locked", time.Unix(1600000000, 0)),
+			`health "a"`: newTestCheckResult(nagiosPlugin.STATUS_OK, "Cluster is green", time.Unix(1600000010, 0)),
+		},
+	}
+
+	recorder := httptest.NewRecorder()
+	server.handleMetrics(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	b, err := ioutil.ReadAll(recorder.Result().Body)
+	assert.NoError(t, err)
+
+	assert.Equal(t, "text/plain; version=0.0.4; charset=utf-8", recorder.Header().Get("Content-Type"))
+	assert.Equal(t, `# HELP check_elasticsearch_status The status of check (0: OK, 1: WARNING, 2: CRITICAL, 3: UNKNOWN)
+# TYPE check_elasticsearch_status gauge
+check_elasticsearch_status{check="health \"a\""} 0
+check_elasticsearch_status{check="locked"} 2
+# HELP check_elasticsearch_perfdata The perfdata of check
+# TYPE check_elasticsearch_perfdata gauge
+check_elasticsearch_perfdata{check="health \"a\"",label="nbIndicesLocked",unit=""} 0
+check_elasticsearch_perfdata{check="locked",label="nbIndicesLocked",unit=""} 2
+# HELP check_elasticsearch_last_run_timestamp_seconds The timestamp of the last run of check
+# TYPE check_elasticsearch_last_run_timestamp_seconds gauge
+check_elasticsearch_last_run_timestamp_seconds{check="health \"a\""} 1600000010
+check_elasticsearch_last_run_timestamp_seconds{check="locked"} 1600000000
+# HELP check_elasticsearch_duration_seconds The duration of the last run of check
+# TYPE check_elasticsearch_duration_seconds gauge
+check_elasticsearch_duration_seconds{check="health \"a\""} 1.5
+check_elasticsearch_duration_seconds{check="locked"} 1.5
+`, string(b))
+}
+
+func TestHandleCheck(t *testing.T) {
+
+	server := &checkServer{
+		checks: []checkDefinition{{Name: "locked"}, {Name: "health"}},
+		results: map[string]*checkResult{
+			"locked": newTestCheckResult(nagiosPlugin.STATUS_CRITICAL, "Some indices are locked", time.Now()),
+		},
+	}
+
+	testCases := []struct {
+		name       string
+		path       string
+		statusCode int
+		body       string
+	}{
+		{
+			name:       "check run",
+			path:       "/checks/locked",
+			statusCode: 200,
+			body:       "CRITICAL - Some indices are locked|nbIndicesLocked=2;;0;; \n",
+		},
+		{
+			name:       "check not run yet",
+			path:       "/checks/health",
+			statusCode: 503,
+		},
+		{
+			name:       "check not declared",
+			path:       "/checks/foo",
+			statusCode: 404,
+		},
+	}
+
+	for _, testCase := range testCases {
+		recorder := httptest.NewRecorder()
+		server.handleCheck(recorder, httptest.NewRequest("GET", testCase.path, nil))
+		assert.Equal(t, testCase.statusCode, recorder.Code, testCase.name)
+		if testCase.body != "" {
+			assert.Equal(t, testCase.body, recorder.Body.String(), testCase.name)
+		}
+	}
+}
+
+func TestSchedule(t *testing.T) {
+
+	server := &checkServer{
+		checks:  []checkDefinition{{Name: "locked"}, {Name: "health"}},
+		running: map[string]bool{"locked": true},
+	}
+	jobs := make(chan checkDefinition, len(server.checks))
+
+	// When check is still running, it is skipped
+	server.schedule(context.Background(), jobs)
+	close(jobs)
+	scheduled := make([]string, 0)
+	for check := range jobs {
+		scheduled = append(scheduled, check.Name)
+	}
+	assert.Equal(t, []string{"health"}, scheduled)
+	assert.False(t, server.markRunning("health"))
+
+	// When check is finished, it can be run again
+	server.unmarkRunning("locked")
+	assert.True(t, server.markRunning("locked"))
+}
+
+func TestStart(t *testing.T) {
+
+	server := &checkServer{
+		checks:   []checkDefinition{{Name: "foo", Command: "foo"}},
+		interval: time.Hour,
+		workers:  1,
+		results:  map[string]*checkResult{},
+		running:  map[string]bool{},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The checks are run once started
+	server.start(ctx)
+	assert.Eventually(t, func() bool {
+		server.mutex.RLock()
+		defer server.mutex.RUnlock()
+		return server.results["foo"] != nil && len(server.running) == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestEscapeLabelValue(t *testing.T) {
+
+	testCases := []struct {
+		value    string
+		expected string
+	}{
+		{value: "locked", expected: "locked"},
+		{value: `a "quoted" value`, expected: `a \"quoted\" value`},
+		{value: `C:\path`, expected: `C:\\path`},
+		{value: "multi\nline", expected: `multi\nline`},
+	}
+
+	for _, testCase := range testCases {
+		assert.Equal(t, testCase.expected, escapeLabelValue(testCase.value))
+	}
+}